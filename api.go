@@ -1,228 +1,655 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // API описывает HTTP API для работы с заметками.
 type API struct {
-	store *NotesStore
-	auth  AuthMiddleware
+	store        *NotesStore
+	auth         *AuthMiddleware
+	bodyLimit    *BodyLimitMiddleware
+	metricsToken string
+	health       *Health
+	// idempotencyTTL — окно хранения ключей идемпотентности в наносекундах.
+	idempotencyTTL  atomic.Int64
+	events          *EventBroker
+	eventsHeartbeat time.Duration
 }
 
 // NewAPI создает API с заданным хранилищем и учетными данными.
 func NewAPI(store *NotesStore, user, password string) *API {
-	return &API{store: store, auth: AuthMiddleware{User: user, Password: password}}
+	api := &API{
+		store:     store,
+		auth:      NewAuthMiddleware(user, password, store.VerifyAPIToken),
+		bodyLimit: &BodyLimitMiddleware{},
+	}
+	api.idempotencyTTL.Store(int64(24 * time.Hour))
+	return api
+}
+
+// SetCredentials заменяет логин и пароль HTTP API без перезапуска.
+func (a *API) SetCredentials(user, password string) {
+	a.auth.SetCredentials(user, password)
+}
+
+// EnableMetrics публикует /metrics, доступный по отдельному токену, а не по учетным данным API.
+func (a *API) EnableMetrics(token string) {
+	a.metricsToken = token
+}
+
+// EnableHealth публикует /healthz и /readyz без авторизации.
+func (a *API) EnableHealth(health *Health) {
+	a.health = health
+}
+
+// SetMaxBodyBytes задает максимальный размер тела запроса; 0 отключает ограничение.
+func (a *API) SetMaxBodyBytes(limit int64) {
+	a.bodyLimit.SetLimit(limit)
+}
+
+// SetIdempotencyTTL задает, сколько хранятся ключи Idempotency-Key и сохраненные ответы.
+func (a *API) SetIdempotencyTTL(ttl time.Duration) {
+	a.idempotencyTTL.Store(int64(ttl))
+}
+
+// apiPrefix — префикс текущей версии API.
+const apiPrefix = "/v1"
+
+// apiRoute описывает операцию API. Legacy означает, что операция доступна и по
+// старому пути без префикса версии как устаревший синоним.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Legacy  bool
+}
+
+// routes возвращает все операции API. Таблица сверяется со спецификацией в CheckContract.
+func (a *API) routes() []apiRoute {
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/v1/notes", Handler: a.handleListNotes, Legacy: true},
+		{Method: http.MethodPost, Path: "/v1/notes", Handler: a.idempotent(a.handleCreateNote), Legacy: true},
+		{Method: http.MethodDelete, Path: "/v1/notes", Handler: a.handleDeleteNotes},
+		{Method: http.MethodPost, Path: "/v1/notes:batch", Handler: a.idempotent(a.handleNotesBatch)},
+		{Method: http.MethodGet, Path: "/v1/notes/{id}", Handler: a.handleGetNote},
+		{Method: http.MethodPatch, Path: "/v1/notes/{id}", Handler: a.handleUpdateNote},
+		{Method: http.MethodDelete, Path: "/v1/notes/{id}", Handler: a.handleDeleteNote, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/notes/{id}/links", Handler: a.handleListNoteLinks, Legacy: true},
+		{Method: http.MethodPost, Path: "/v1/notes/{id}/links", Handler: a.idempotent(a.handleCreateLink), Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/notes/{id}/backlinks", Handler: a.handleBacklinks, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/links", Handler: a.handleListLinks},
+		{Method: http.MethodPost, Path: "/v1/links:batch", Handler: a.idempotent(a.handleLinksBatch)},
+		{Method: http.MethodPatch, Path: "/v1/links/{id}", Handler: a.handleUpdateLink, Legacy: true},
+		{Method: http.MethodDelete, Path: "/v1/links/{id}", Handler: a.handleDeleteLink, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/sync", Handler: a.handleSync},
+		{Method: http.MethodGet, Path: "/v1/events", Handler: a.handleEvents},
+		{Method: http.MethodPost, Path: "/v1/sync", Handler: a.idempotent(a.handlePush)},
+		{Method: http.MethodGet, Path: "/v1/webhooks", Handler: a.handleListWebhooks},
+		{Method: http.MethodPost, Path: "/v1/webhooks", Handler: a.handleCreateWebhook},
+		{Method: http.MethodDelete, Path: "/v1/webhooks/{id}", Handler: a.handleDeleteWebhook},
+		{Method: http.MethodGet, Path: "/v1/webhooks/{id}/deliveries", Handler: a.handleListDeliveries},
+		{Method: http.MethodPost, Path: "/v1/webhooks/{id}/deliveries/{delivery_id}/retry", Handler: a.handleRetryDelivery},
+		{Method: http.MethodGet, Path: "/v1/export", Handler: a.handleExport},
+		{Method: http.MethodPost, Path: "/v1/import", Handler: a.idempotent(a.handleImport)},
+		{Method: http.MethodGet, Path: "/v1/graph", Handler: a.handleGraph, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/graph/neighbors", Handler: a.handleGraphNeighbors, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/graph/path", Handler: a.handleGraphPath, Legacy: true},
+		{Method: http.MethodGet, Path: "/v1/graph/components", Handler: a.handleGraphComponents, Legacy: true},
+	}
 }
 
 // Handler возвращает http.Handler со всеми маршрутами API.
 func (a *API) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/notes", a.handleNotes)
-	mux.HandleFunc("/notes/", a.handleNoteByID)
-	mux.HandleFunc("/links/", a.handleLinkByID)
-	return LoggingMiddleware(a.auth.Wrap(mux))
-}
-
-// handleNotes обрабатывает создание и получение списка заметок.
-func (a *API) handleNotes(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		a.handleListNotes(w, r)
-	case http.MethodPost:
-		a.handleCreateNote(w, r)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	allowed := make(map[string][]string)
+	for _, route := range a.routes() {
+		mux.Handle(route.Method+" "+route.Path, route.Handler)
+		allowed[route.Path] = append(allowed[route.Path], route.Method)
+		if route.Legacy {
+			legacy := strings.TrimPrefix(route.Path, apiPrefix)
+			mux.Handle(route.Method+" "+legacy, deprecatedRoute(route.Handler))
+			allowed[legacy] = append(allowed[legacy], route.Method)
+		}
+	}
+	// Шаблоны без метода менее специфичны и срабатывают только для неподдерживаемых методов.
+	for path, methods := range allowed {
+		mux.Handle(path, methodNotAllowed(methods))
+	}
+	mux.HandleFunc("/", writeNotFound)
+
+	root := http.NewServeMux()
+	if a.metricsToken != "" {
+		root.Handle("/metrics", appMetrics.Handler(a.metricsToken))
+	}
+	root.Handle("GET /openapi.json", OpenAPIHandler())
+	if a.health != nil {
+		root.Handle("GET /healthz", a.health.LivenessHandler())
+		root.Handle("GET /readyz", a.health.ReadinessHandler())
 	}
+	root.Handle("/", a.auth.Wrap(a.bodyLimit.Wrap(mux)))
+	return RequestIDMiddleware(TracingMiddleware(LoggingMiddleware(appMetrics.Middleware(root))))
+}
+
+// deprecatedRoute помечает ответ старого пути заголовками Deprecation и Link
+// со ссылкой на путь в текущей версии API.
+func deprecatedRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+">; rel=\"successor-version\"")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), legacyRouteKey, true)))
+	})
 }
 
-// handleNoteByID маршрутизирует запросы для конкретной заметки.
-func (a *API) handleNoteByID(w http.ResponseWriter, r *http.Request) {
-	trimmed := strings.TrimPrefix(r.URL.Path, "/notes/")
-	parts := strings.Split(trimmed, "/")
-	if len(parts) == 0 || parts[0] == "" {
-		http.NotFound(w, r)
+// methodNotAllowed отвечает 405 с заголовком Allow, перечисляющим поддерживаемые методы.
+func methodNotAllowed(methods []string) http.Handler {
+	sorted := append([]string(nil), methods...)
+	sort.Strings(sorted)
+	allow := strings.Join(sorted, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		writeMethodNotAllowed(w, r)
+	})
+}
+
+// handleListNotes возвращает список заметок пользователя.
+func (a *API) handleListNotes(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	id, err := strconv.Atoi(parts[0])
-	if err != nil || id <= 0 {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+	notes, err := a.store.ListNotes(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list notes: %w", err))
 		return
 	}
 
-	if len(parts) == 1 {
-		a.handleDeleteNote(w, r, id)
+	writeJSONWithETag(w, r, http.StatusOK, notes, "")
+}
+
+// handleCreateNote создает заметку пользователя.
+func (a *API) handleCreateNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if len(parts) == 2 && parts[1] == "links" {
-		a.handleLinks(w, r, id)
+	var payload CreateNoteRequest
+	if err := decodeJSON(r, "CreateNoteRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	payload.Text = strings.TrimSpace(payload.Text)
+	if payload.Text == "" {
+		writeError(w, r, newValidation("invalid_payload", "text is required"))
+		return
+	}
+
+	note, err := a.storeFor(r).AddNote(r.Context(), userID, payload.Text)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("save note: %w", err))
 		return
 	}
 
-	http.NotFound(w, r)
+	w.Header().Set("Location", apiPrefix+"/notes/"+strconv.FormatUint(uint64(note.ID), 10))
+	writeJSONWithETag(w, r, http.StatusCreated, note, versionETag(note.Version))
 }
 
-// handleLinkByID позволяет редактировать и удалять связь.
-func (a *API) handleLinkByID(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/links/")
-	linkID, err := strconv.Atoi(idStr)
-	if err != nil || linkID <= 0 {
-		http.Error(w, "invalid link id", http.StatusBadRequest)
+// handleGetNote возвращает активную заметку пользователя.
+func (a *API) handleGetNote(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	userID, err := userIDFromQuery(r)
+	note, found, err := a.store.GetNote(r.Context(), userID, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("get note: %w", err))
 		return
 	}
+	if !found {
+		writeError(w, r, errNoteNotFound)
+		return
+	}
+	writeJSONWithETag(w, r, http.StatusOK, note, versionETag(note.Version))
+}
 
-	switch r.Method {
-	case http.MethodPatch:
-		var payload struct {
-			ToID uint `json:"to_id"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ToID == 0 {
-			http.Error(w, "invalid payload", http.StatusBadRequest)
-			return
-		}
-		updated, err := a.store.UpdateLink(r.Context(), userID, uint(linkID), payload.ToID)
-		if err != nil {
-			http.Error(w, "failed to update link", http.StatusInternalServerError)
-			return
-		}
-		if !updated {
-			http.Error(w, "link not found", http.StatusNotFound)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	case http.MethodDelete:
-		deleted, err := a.store.DeleteLink(r.Context(), userID, uint(linkID))
-		if err != nil {
-			http.Error(w, "failed to delete link", http.StatusInternalServerError)
-			return
-		}
-		if !deleted {
-			http.Error(w, "link not found", http.StatusNotFound)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// handleUpdateNote заменяет текст заметки, если ее версия совпадает с If-Match.
+func (a *API) handleUpdateNote(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var payload UpdateNoteRequest
+	if err := decodeJSON(r, "UpdateNoteRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	payload.Text = strings.TrimSpace(payload.Text)
+	if payload.Text == "" {
+		writeError(w, r, newValidation("invalid_payload", "text is required"))
+		return
+	}
+
+	note, found, err := a.store.UpdateNote(r.Context(), userID, id, payload.Text, version)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("update note: %w", err))
+		return
 	}
+	if !found {
+		writeError(w, r, errNoteNotFound)
+		return
+	}
+	writeJSONWithETag(w, r, http.StatusOK, note, versionETag(note.Version))
 }
 
-// handleListNotes возвращает список заметок пользователя.
-func (a *API) handleListNotes(w http.ResponseWriter, r *http.Request) {
-	userID, err := userIDFromQuery(r)
+// handleDeleteNote помечает заметку как удаленную, если ее версия совпадает с If-Match.
+func (a *API) handleDeleteNote(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	notes, err := a.store.ListNotes(r.Context(), userID)
+	deleted, err := a.store.DeleteNote(r.Context(), userID, id, version)
 	if err != nil {
-		http.Error(w, "failed to list notes", http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("delete note: %w", err))
+		return
+	}
+	if !deleted {
+		writeError(w, r, errNoteNotFound)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, notes)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleCreateNote создает заметку пользователя.
-func (a *API) handleCreateNote(w http.ResponseWriter, r *http.Request) {
-	userID, err := userIDFromQuery(r)
+// handleListNoteLinks возвращает связи заметки.
+func (a *API) handleListNoteLinks(w http.ResponseWriter, r *http.Request) {
+	userID, fromID, err := userAndPathID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	var payload struct {
-		Text string `json:"text"`
+	links, err := a.store.ListLinksForNote(r.Context(), userID, fromID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list links: %w", err))
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+	writeJSONWithETag(w, r, http.StatusOK, links, "")
+}
+
+// handleCreateLink создает связь от заметки к другой заметке.
+func (a *API) handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	userID, fromID, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	payload.Text = strings.TrimSpace(payload.Text)
-	if payload.Text == "" {
-		http.Error(w, "text is required", http.StatusBadRequest)
+
+	var payload CreateLinkRequest
+	if err := decodeJSON(r, "CreateLinkRequest", &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	link, err := a.storeFor(r).AddLink(r.Context(), userID, fromID, payload.ToID, payload.Kind)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("add link: %w", err))
+		return
+	}
+	writeJSONWithETag(w, r, http.StatusCreated, link, versionETag(link.Version))
+}
 
-	note, err := a.store.AddNote(r.Context(), userID, payload.Text)
+// handleBacklinks возвращает связи, указывающие на заметку.
+func (a *API) handleBacklinks(w http.ResponseWriter, r *http.Request) {
+	userID, toID, err := userAndPathID(r)
 	if err != nil {
-		http.Error(w, "failed to save note", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, note)
+	links, err := a.store.ListBacklinks(r.Context(), userID, toID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list backlinks: %w", err))
+		return
+	}
+	writeJSONWithETag(w, r, http.StatusOK, links, "")
 }
 
-// handleDeleteNote помечает заметку как удаленную.
-func (a *API) handleDeleteNote(w http.ResponseWriter, r *http.Request, id int) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// handleListLinks возвращает все видимые связи пользователя.
+func (a *API) handleListLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	userID, err := userIDFromQuery(r)
+	links, err := a.store.ListLinks(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("list links: %w", err))
 		return
 	}
+	writeJSONWithETag(w, r, http.StatusOK, links, "")
+}
 
-	deleted, err := a.store.DeleteNote(r.Context(), userID, id)
+// handleUpdateLink меняет целевую заметку связи, если ее версия совпадает с If-Match.
+// Новая версия возвращается в заголовке ETag.
+func (a *API) handleUpdateLink(w http.ResponseWriter, r *http.Request) {
+	userID, linkID, err := userAndPathID(r)
 	if err != nil {
-		http.Error(w, "failed to delete note", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	if !deleted {
-		http.Error(w, "note not found", http.StatusNotFound)
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	var payload UpdateLinkRequest
+	if err := decodeJSON(r, "UpdateLinkRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	link, updated, err := a.store.UpdateLink(r.Context(), userID, uint(linkID), payload.ToID, version)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("update link: %w", err))
+		return
+	}
+	if !updated {
+		writeError(w, r, errLinkNotFound)
+		return
+	}
+	w.Header().Set("ETag", versionETag(link.Version))
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleLinks создает и возвращает связи между заметками.
-func (a *API) handleLinks(w http.ResponseWriter, r *http.Request, fromID int) {
-	userID, err := userIDFromQuery(r)
+// handleDeleteLink удаляет связь, если ее версия совпадает с If-Match.
+func (a *API) handleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	userID, linkID, err := userAndPathID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		links, err := a.store.ListLinksForNote(r.Context(), userID, fromID)
-		if err != nil {
-			http.Error(w, "failed to list links", http.StatusInternalServerError)
+	deleted, err := a.store.DeleteLink(r.Context(), userID, uint(linkID), version)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("delete link: %w", err))
+		return
+	}
+	if !deleted {
+		writeError(w, r, errLinkNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSync возвращает изменения пользователя после токена since.
+func (a *API) handleSync(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var since int64
+	if token := r.URL.Query().Get("since"); token != "" {
+		since, err = strconv.ParseInt(token, 10, 64)
+		if err != nil || since < 0 {
+			writeError(w, r, invalidParameter("since"))
 			return
 		}
-		writeJSON(w, http.StatusOK, links)
-	case http.MethodPost:
-		var payload struct {
-			ToID int `json:"to_id"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ToID <= 0 {
-			http.Error(w, "invalid payload", http.StatusBadRequest)
+	}
+	limit := defaultSyncLimit
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = positiveIntFromQuery(r, "limit")
+		if err != nil || limit > maxSyncLimit {
+			writeError(w, r, invalidParameter("limit"))
 			return
 		}
-		link, err := a.store.AddLink(r.Context(), userID, fromID, payload.ToID)
+	}
+
+	batch, err := a.store.Changes(r.Context(), userID, since, limit)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("load changes: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, SyncResponse{
+		Notes:        emptyIfNil(batch.Notes),
+		Links:        emptyIfNil(batch.Links),
+		DeletedNotes: emptyIfNil(batch.DeletedNotes),
+		DeletedLinks: emptyIfNil(batch.DeletedLinks),
+		Token:        strconv.FormatInt(batch.Seq, 10),
+		HasMore:      batch.HasMore,
+	})
+}
+
+// handlePush применяет пакет изменений клиента и сообщает о конфликтах.
+func (a *API) handlePush(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var payload PushRequest
+	if err := decodeJSON(r, "PushRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	results, err := a.storeFor(r).ApplyChanges(r.Context(), userID, payload.Changes)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("apply changes: %w", err))
+		return
+	}
+
+	response := PushResponse{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case SyncApplied:
+			response.Applied++
+		case SyncConflict:
+			response.Conflicts++
+		default:
+			response.Rejected++
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleExport отдает выгрузку заметок и связей пользователя файлом в формате json,
+// markdown (zip) или csv.
+func (a *API) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	format := ExportJSON
+	if value := r.URL.Query().Get("format"); value != "" {
+		format = ExportFormat(value)
+	}
+	if !format.Valid() {
+		writeError(w, r, invalidParameter("format"))
+		return
+	}
+
+	dump, err := a.store.ExportUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("export: %w", err))
+		return
+	}
+	var body bytes.Buffer
+	if err := WriteExport(&body, dump, format); err != nil {
+		writeError(w, r, fmt.Errorf("export: %w", err))
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+format.FileName(userID, dump.ExportedAt)+`"`)
+	writeText(w, format.ContentType(), body.String())
+}
+
+// handleGraph отдает граф заметок пользователя в формате dot, mermaid, svg или json.
+func (a *API) handleGraph(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "dot" && format != "mermaid" && format != "svg" {
+		writeError(w, r, invalidParameter("format"))
+		return
+	}
+
+	graph, err := a.store.UserGraph(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("load graph: %w", err))
+		return
+	}
+
+	switch format {
+	case "dot":
+		writeText(w, "text/vnd.graphviz; charset=utf-8", renderGraphDOT(graph))
+	case "mermaid":
+		writeText(w, "text/plain; charset=utf-8", renderGraphMermaid(graph))
+	case "svg":
+		writeText(w, "image/svg+xml", renderGraphSVG(graph))
+	default:
+		writeJSON(w, http.StatusOK, graph)
+	}
+}
+
+// handleGraphNeighbors возвращает окрестность заметки на заданную глубину.
+func (a *API) handleGraphNeighbors(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	noteID, err := positiveIntFromQuery(r, "note_id")
+	if err != nil {
+		writeError(w, r, invalidParameter("note_id"))
+		return
+	}
+	depth := 1
+	if r.URL.Query().Get("depth") != "" {
+		depth, err = positiveIntFromQuery(r, "depth")
 		if err != nil {
-			http.Error(w, "failed to add link", http.StatusInternalServerError)
+			writeError(w, r, invalidParameter("depth"))
 			return
 		}
-		writeJSON(w, http.StatusCreated, link)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
+
+	neighborhood, found, err := a.store.Neighborhood(r.Context(), userID, noteID, depth)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("load neighbors: %w", err))
+		return
+	}
+	if !found {
+		writeError(w, r, errNoteNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, neighborhood)
+}
+
+// handleGraphPath возвращает кратчайшую цепочку связей между двумя заметками.
+func (a *API) handleGraphPath(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	fromID, err := positiveIntFromQuery(r, "from")
+	if err != nil {
+		writeError(w, r, invalidParameter("from"))
+		return
+	}
+	toID, err := positiveIntFromQuery(r, "to")
+	if err != nil {
+		writeError(w, r, invalidParameter("to"))
+		return
+	}
+	undirected := r.URL.Query().Get("undirected") == "true"
+
+	path, found, err := a.store.ShortestPath(r.Context(), userID, fromID, toID, undirected)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("find path: %w", err))
+		return
+	}
+	if !found {
+		writeError(w, r, newNotFound("path_not_found", "path not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, PathResponse{Path: path, Length: len(path) - 1})
+}
+
+// handleGraphComponents возвращает компоненты связности и циклы зависимостей.
+func (a *API) handleGraphComponents(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	components, err := a.store.Components(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list components: %w", err))
+		return
+	}
+	cycles, err := a.store.DependencyCycles(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("detect cycles: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ComponentsResponse{Components: components, Cycles: cycles})
+}
+
+// userAndPathID извлекает идентификатор пользователя из параметров запроса
+// и положительный идентификатор {id} из пути.
+func userAndPathID(r *http.Request) (int64, int, error) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, 0, invalidParameter("id")
+	}
+	return userID, id, nil
 }
 
 // userIDFromQuery извлекает идентификатор пользователя из параметров запроса.
@@ -235,6 +662,33 @@ func userIDFromQuery(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// positiveIntFromQuery извлекает положительное целое число из параметров запроса.
+func positiveIntFromQuery(r *http.Request, key string) (int, error) {
+	value, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 {
+		return 0, strconv.ErrRange
+	}
+	return value, nil
+}
+
+// writeText отправляет текстовый ответ с заданным типом содержимого.
+func writeText(w http.ResponseWriter, contentType, body string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(body))
+}
+
+// emptyIfNil заменяет nil пустым срезом, чтобы в JSON был [] вместо null.
+func emptyIfNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
 // writeJSON сериализует ответ в JSON.
 func writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
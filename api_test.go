@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+)
+
+// newContractAPI создает API без базы данных, как подкоманда openapi -check.
+func newContractAPI() *API {
+	api := NewAPI(&NotesStore{}, "contract", "check")
+	api.EnableHealth(&Health{})
+	return api
+}
+
+func TestMain(m *testing.M) {
+	// Журнал запросов в тестах не нужен.
+	setupLogging(os.Stderr, LogFormatText, slog.LevelWarn)
+	os.Exit(m.Run())
+}
+
+func TestAPIMatchesOpenAPIContract(t *testing.T) {
+	for _, problem := range CheckContract(newContractAPI(), "contract", "check") {
+		t.Error(problem)
+	}
+}
+
+func TestCheckContractReportsUndescribedRoute(t *testing.T) {
+	paths := apiSpec.Paths
+	defer func() { apiSpec.Paths = paths }()
+	apiSpec.Paths = maps.Clone(paths)
+	delete(apiSpec.Paths, apiPrefix+"/notes")
+
+	problems := CheckContract(newContractAPI(), "contract", "check")
+	want := "GET " + apiPrefix + "/notes: route is not described in the specification"
+	if !slices.Contains(problems, want) {
+		t.Errorf("problems = %q, want %q among them", problems, want)
+	}
+}
+
+func TestCheckContractReportsRejectedCredentials(t *testing.T) {
+	problems := CheckContract(newContractAPI(), "contract", "wrong")
+	if len(problems) == 0 {
+		t.Fatal("wrong credentials are not reported")
+	}
+}
+
+func TestAPIServesOpenAPIDocument(t *testing.T) {
+	server := httptest.NewServer(newContractAPI().Handler())
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !bytes.Equal(body, openAPIDocument) {
+		t.Error("served document differs from the embedded specification")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+// CreateNoteRequest описывает тело POST /v1/notes.
+type CreateNoteRequest struct {
+	Text string `json:"text"`
+}
+
+// UpdateNoteRequest описывает тело PATCH /v1/notes/{id}.
+type UpdateNoteRequest struct {
+	Text string `json:"text"`
+}
+
+// CreateLinkRequest описывает тело POST /v1/notes/{id}/links. Пустой вид означает reference.
+type CreateLinkRequest struct {
+	ToID int      `json:"to_id"`
+	Kind LinkKind `json:"kind,omitempty"`
+}
+
+// UpdateLinkRequest описывает тело PATCH /v1/links/{id}.
+type UpdateLinkRequest struct {
+	ToID uint `json:"to_id"`
+}
+
+// PathResponse описывает ответ GET /v1/graph/path.
+type PathResponse struct {
+	Path   []Note `json:"path"`
+	Length int    `json:"length"`
+}
+
+// ComponentsResponse описывает ответ GET /v1/graph/components.
+type ComponentsResponse struct {
+	Components [][]uint `json:"components"`
+	Cycles     [][]uint `json:"cycles"`
+}
+
+// SyncResponse описывает ответ GET /v1/sync. Token передается в since при следующем запросе.
+type SyncResponse struct {
+	Notes        []Note     `json:"notes"`
+	Links        []NoteLink `json:"links"`
+	DeletedNotes []uint     `json:"deleted_notes"`
+	DeletedLinks []uint     `json:"deleted_links"`
+	Token        string     `json:"token"`
+	HasMore      bool       `json:"has_more"`
+}
+
+// PushRequest описывает тело POST /v1/sync.
+type PushRequest struct {
+	Changes []SyncChange `json:"changes"`
+}
+
+// PushResponse описывает ответ POST /v1/sync: результат для каждого изменения по порядку.
+type PushResponse struct {
+	Results   []SyncResult `json:"results"`
+	Applied   int          `json:"applied"`
+	Conflicts int          `json:"conflicts"`
+	Rejected  int          `json:"rejected"`
+}
+
+// CreateWebhookRequest описывает тело POST /v1/webhooks. Пустой Events означает все события,
+// пустой Secret — случайный секрет, который вернется в ответе.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// NoteBatchOperation описывает операцию POST /v1/notes:batch: create с Text,
+// delete и restore с ID. Version необязательна: без нее версия не проверяется.
+type NoteBatchOperation struct {
+	Op       string `json:"op"`
+	ClientID string `json:"client_id,omitempty"`
+	ID       uint   `json:"id,omitempty"`
+	Version  int64  `json:"version,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// NotesBatchRequest описывает тело POST /v1/notes:batch. Пустой Mode означает atomic.
+type NotesBatchRequest struct {
+	Mode       BatchMode            `json:"mode,omitempty"`
+	Operations []NoteBatchOperation `json:"operations"`
+}
+
+// LinkBatchOperation описывает операцию POST /v1/links:batch: create с FromID и ToID
+// или delete с ID.
+type LinkBatchOperation struct {
+	Op       string   `json:"op"`
+	ClientID string   `json:"client_id,omitempty"`
+	ID       uint     `json:"id,omitempty"`
+	Version  int64    `json:"version,omitempty"`
+	FromID   uint     `json:"from_id,omitempty"`
+	ToID     uint     `json:"to_id,omitempty"`
+	Kind     LinkKind `json:"kind,omitempty"`
+}
+
+// LinksBatchRequest описывает тело POST /v1/links:batch. Пустой Mode означает atomic.
+type LinksBatchRequest struct {
+	Mode       BatchMode            `json:"mode,omitempty"`
+	Operations []LinkBatchOperation `json:"operations"`
+}
+
+// BatchResponse описывает ответ на пакет операций.
+type BatchResponse struct {
+	Mode      BatchMode    `json:"mode"`
+	Committed bool         `json:"committed"`
+	Results   []SyncResult `json:"results"`
+	Applied   int          `json:"applied"`
+	Failed    int          `json:"failed"`
+}
+
+// BulkDeleteResponse описывает ответ DELETE /v1/notes.
+type BulkDeleteResponse struct {
+	Deleted int64 `json:"deleted"`
+	DryRun  bool  `json:"dry_run"`
+}
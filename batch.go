@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BatchMode определяет, как пакет операций реагирует на ошибку отдельной операции.
+type BatchMode string
+
+const (
+	// BatchAtomic применяет пакет целиком или не применяет ничего.
+	BatchAtomic BatchMode = "atomic"
+	// BatchPartial применяет успешные операции и сообщает об ошибках остальных.
+	BatchPartial BatchMode = "partial"
+)
+
+// Дополнительные результаты операций пакета в режиме atomic.
+const (
+	// BatchRolledBack означает, что операция выполнилась, но отменена вместе с пакетом.
+	BatchRolledBack = "rolled_back"
+	// BatchSkipped означает, что операция не выполнялась, потому что пакет уже отменен.
+	BatchSkipped = "skipped"
+)
+
+// Операции пакета. Их смысл совпадает с одноименными изменениями синхронизации.
+const (
+	// BatchOpCreate создает заметку или связь.
+	BatchOpCreate = "create"
+	// BatchOpDelete удаляет заметку или связь.
+	BatchOpDelete = "delete"
+	// BatchOpRestore возвращает удаленную заметку.
+	BatchOpRestore = "restore"
+)
+
+// errBatchItemFailed откатывает точку сохранения операции, которая не применилась.
+var errBatchItemFailed = errors.New("batch operation failed")
+
+// errFilterRequired возвращается, если массовое удаление вызвано без фильтра и без all=true.
+var errFilterRequired = newValidation("filter_required", "tag, created_after, created_before or all=true is required")
+
+// errInvalidTag возвращается для тега с символами, отличными от букв, цифр, _ и -.
+var errInvalidTag = newValidation("invalid_tag", "tag must be 1 to 64 letters, digits, _ or -")
+
+// tagPattern описывает допустимый тег без ведущего #.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,64}$`)
+
+// NoteFilter отбирает активные заметки для массовых операций. Пустой фильтр подходит
+// ко всем заметкам. Tag ищется в тексте как #tag без учета регистра.
+type NoteFilter struct {
+	Tag           string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// Empty сообщает, что фильтр не задает ни одного условия.
+func (f NoteFilter) Empty() bool {
+	return f.Tag == "" && f.CreatedAfter.IsZero() && f.CreatedBefore.IsZero()
+}
+
+// apply добавляет условия фильтра к запросу по заметкам.
+func (f NoteFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Tag != "" {
+		// Тег должен стоять отдельным словом: #work не совпадает с #workshop и a#work.
+		query = query.Where("text ~* ?", `(^|[^[:alnum:]_])#`+f.Tag+`([^[:alnum:]_-]|$)`)
+	}
+	if !f.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", f.CreatedBefore)
+	}
+	return query
+}
+
+// DeleteNotes помечает удаленными активные заметки пользователя, подходящие под filter,
+// и возвращает их число. При dryRun заметки только подсчитываются. При политике cascade
+// связи удаленных заметок удаляются в той же транзакции.
+func (s *NotesStore) DeleteNotes(ctx context.Context, userID int64, filter NoteFilter, dryRun bool) (int64, error) {
+	if filter.Tag != "" && !tagPattern.MatchString(filter.Tag) {
+		return 0, errInvalidTag
+	}
+	var count int64
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		query := filter.apply(tx.Model(&Note{}).Where("user_id = ? AND status = ?", userID, NoteStatusActive))
+		if dryRun {
+			return query.Count(&count).Error
+		}
+		// Набор заметок не меняется до конца транзакции: все записи пользователя
+		// берут блокировку его журнала изменений.
+		var ids []uint
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		count = int64(len(ids))
+		if count == 0 {
+			return nil
+		}
+		err := tx.Model(&Note{}).Where("id IN ?", ids).
+			Updates(map[string]any{"status": NoteStatusDeleted, "version": gorm.Expr("version + 1")}).Error
+		if err != nil || s.linkPolicy != LinkPolicyCascade {
+			return err
+		}
+		// Удаляются только связи заметок из этого вызова: удаленные раньше заметки
+		// можно восстановить вместе со связями.
+		return tx.Where("user_id = ? AND (from_id IN ? OR to_id IN ?)", userID, ids, ids).Delete(&NoteLink{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 && !dryRun {
+		s.changed(userID)
+	}
+	return count, nil
+}
+
+// inTx возвращает хранилище, выполняющее запросы в транзакции tx. Оно не вызывает
+// onChange: об изменениях сообщает вызывающий после фиксации транзакции.
+func (s *NotesStore) inTx(tx *gorm.DB) *NotesStore {
+	return &NotesStore{db: tx, linkPolicy: s.linkPolicy}
+}
+
+// ApplyBatch выполняет операции в одной транзакции. Каждая операция работает в своей
+// точке сохранения, поэтому отклоненная операция не оставляет следов. В режиме
+// BatchPartial остальные операции применяются, в режиме BatchAtomic первая неудача
+// отменяет весь пакет: выполненные операции получают статус rolled_back, оставшиеся —
+// skipped, а committed равен false. Версия anyVersion отключает проверку версии.
+func (s *NotesStore) ApplyBatch(ctx context.Context, userID int64, mode BatchMode, changes []SyncChange) ([]SyncResult, bool, error) {
+	results := make([]SyncResult, 0, len(changes))
+	applied := false
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		created := make(map[string]uint)
+		for i, change := range changes {
+			result := SyncResult{Index: i, ClientID: change.ClientID}
+			if change.Op != SyncOpCreateNote && change.Op != SyncOpCreateLink && change.ID == 0 {
+				result = rejectChange(result, newValidation("invalid_payload", "id is required for "+string(change.Op)))
+			} else {
+				err := tx.Transaction(func(item *gorm.DB) error {
+					var err error
+					result, err = s.inTx(item).applyChange(ctx, userID, change, created)
+					if err == nil && result.Status != SyncApplied {
+						err = errBatchItemFailed
+					}
+					return err
+				})
+				if err != nil && !errors.Is(err, errBatchItemFailed) {
+					return fmt.Errorf("operation %d: %w", i, err)
+				}
+				result.Index = i
+			}
+			results = append(results, result)
+			if result.Status == SyncApplied {
+				applied = true
+			} else if mode == BatchAtomic {
+				return errBatchItemFailed
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchItemFailed) {
+		for i := range results {
+			if results[i].Status == SyncApplied {
+				results[i].Status, results[i].Note, results[i].Link = BatchRolledBack, nil, nil
+			}
+		}
+		for i := len(results); i < len(changes); i++ {
+			results = append(results, SyncResult{Index: i, Status: BatchSkipped, ClientID: changes[i].ClientID})
+		}
+		return results, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if applied {
+		s.changed(userID)
+	}
+	return results, true, nil
+}
+
+// noteBatchOps и linkBatchOps сопоставляют операции пакета изменениям синхронизации.
+var (
+	noteBatchOps = map[string]SyncOp{
+		BatchOpCreate:  SyncOpCreateNote,
+		BatchOpDelete:  SyncOpDeleteNote,
+		BatchOpRestore: SyncOpRestoreNote,
+	}
+	linkBatchOps = map[string]SyncOp{
+		BatchOpCreate: SyncOpCreateLink,
+		BatchOpDelete: SyncOpDeleteLink,
+	}
+)
+
+// handleNotesBatch создает, удаляет и восстанавливает заметки пакетом.
+func (a *API) handleNotesBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var payload NotesBatchRequest
+	if err := decodeJSON(r, "NotesBatchRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	changes := make([]SyncChange, 0, len(payload.Operations))
+	for _, op := range payload.Operations {
+		changes = append(changes, SyncChange{
+			Op:       noteBatchOps[op.Op],
+			ClientID: op.ClientID,
+			ID:       op.ID,
+			Version:  op.Version,
+			Text:     op.Text,
+		})
+	}
+	a.applyBatch(w, r, userID, payload.Mode, changes)
+}
+
+// handleLinksBatch создает и удаляет связи пакетом.
+func (a *API) handleLinksBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var payload LinksBatchRequest
+	if err := decodeJSON(r, "LinksBatchRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	changes := make([]SyncChange, 0, len(payload.Operations))
+	for _, op := range payload.Operations {
+		changes = append(changes, SyncChange{
+			Op:       linkBatchOps[op.Op],
+			ClientID: op.ClientID,
+			ID:       op.ID,
+			Version:  op.Version,
+			FromID:   op.FromID,
+			ToID:     op.ToID,
+			Kind:     op.Kind,
+		})
+	}
+	a.applyBatch(w, r, userID, payload.Mode, changes)
+}
+
+// applyBatch выполняет пакет и отвечает 200, если он зафиксирован, или 422 с результатами
+// операций, если пакет в режиме atomic отменен.
+func (a *API) applyBatch(w http.ResponseWriter, r *http.Request, userID int64, mode BatchMode, changes []SyncChange) {
+	if mode == "" {
+		mode = BatchAtomic
+	}
+	results, committed, err := a.storeFor(r).ApplyBatch(r.Context(), userID, mode, changes)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("apply batch: %w", err))
+		return
+	}
+
+	response := BatchResponse{Mode: mode, Committed: committed, Results: results}
+	for _, result := range results {
+		if result.Status == SyncApplied {
+			response.Applied++
+		} else {
+			response.Failed++
+		}
+	}
+	status := http.StatusOK
+	if !committed {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, response)
+}
+
+// handleDeleteNotes помечает удаленными заметки, подходящие под фильтр из параметров запроса.
+// Без фильтра нужен all=true, чтобы случайный запрос не удалил все заметки.
+func (a *API) handleDeleteNotes(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	filter := NoteFilter{Tag: strings.TrimPrefix(query.Get("tag"), "#")}
+	for name, target := range map[string]*time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if *target, err = timeFromQuery(r, name); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	for _, name := range []string{"all", "dry_run"} {
+		if value := query.Get(name); value != "" && value != "true" && value != "false" {
+			writeError(w, r, invalidParameter(name))
+			return
+		}
+	}
+	all, dryRun := query.Get("all"), query.Get("dry_run")
+	if filter.Empty() && all != "true" {
+		writeError(w, r, errFilterRequired)
+		return
+	}
+
+	count, err := a.store.DeleteNotes(r.Context(), userID, filter, dryRun == "true")
+	if err != nil {
+		writeError(w, r, fmt.Errorf("delete notes: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, BulkDeleteResponse{Deleted: count, DryRun: dryRun == "true"})
+}
+
+// timeFromQuery разбирает момент времени в формате RFC 3339 или дату YYYY-MM-DD (полночь UTC).
+// Отсутствующий параметр дает нулевое время.
+func timeFromQuery(r *http.Request, key string) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, invalidParameter(key)
+}
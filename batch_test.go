@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeleteNotesCascadeKeepsLinksOfEarlierDeletedNotes(t *testing.T) {
+	store, userID := testStore(t)
+	ctx := context.Background()
+	var ids []int
+	for _, text := range []string{"old", "kept", "#tmp batch"} {
+		note, err := store.AddNote(ctx, userID, text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(note.ID))
+	}
+	old, kept, batch := ids[0], ids[1], ids[2]
+	for _, pair := range [][2]int{{old, kept}, {batch, kept}} {
+		if _, err := store.AddLink(ctx, userID, pair[0], pair[1], LinkKindRelated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// При политике hide связь удаленной заметки остается и вернется после восстановления.
+	if _, err := store.DeleteNote(ctx, userID, old, anyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	store.linkPolicy = LinkPolicyCascade
+	count, err := store.DeleteNotes(ctx, userID, NoteFilter{Tag: "tmp"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("deleted %d notes, want 1", count)
+	}
+	var links []NoteLink
+	if err := store.db.Where("user_id = ?", userID).Find(&links).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || int(links[0].FromID) != old {
+		t.Errorf("links = %+v, want only the link of the earlier deleted note", links)
+	}
+}
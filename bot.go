@@ -1,31 +1,60 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // TelegramBot отвечает за обработку сообщений Telegram.
 type TelegramBot struct {
-	store     *NotesStore
-	token     string
-	login     string
-	password  string
-	parseMode string
+	store        *NotesStore
+	token        string
+	credentials  atomic.Pointer[Credentials]
+	pollTimeout  int
+	debug        bool
+	workers      int
+	drainTimeout time.Duration
+	ready        atomic.Bool
 }
 
 // NewTelegramBot создает новый бот с доступом к хранилищу.
 func NewTelegramBot(store *NotesStore, token, login, password string) *TelegramBot {
-	return &TelegramBot{store: store, token: token, login: login, password: password, parseMode: tgbotapi.ModeMarkdown}
+	b := &TelegramBot{
+		store:        store,
+		token:        token,
+		pollTimeout:  30,
+		workers:      8,
+		drainTimeout: 10 * time.Second,
+	}
+	b.SetCredentials(login, password)
+	return b
+}
+
+// SetCredentials атомарно заменяет логин и пароль для /login.
+func (b *TelegramBot) SetCredentials(login, password string) {
+	b.credentials.Store(&Credentials{User: login, Password: password})
 }
 
-// Start запускает цикл получения обновлений.
+// Ready сообщает, получает ли бот обновления от Telegram.
+func (b *TelegramBot) Ready() bool {
+	return b.ready.Load()
+}
+
+// Start запускает цикл получения обновлений и возвращается после отмены ctx.
 func (b *TelegramBot) Start(ctx context.Context) error {
 	if b.token == "" {
 		return errMissingBotToken
@@ -36,31 +65,377 @@ func (b *TelegramBot) Start(ctx context.Context) error {
 		return err
 	}
 
-	bot.Debug = false
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	bot.Debug = b.debug
+	slog.InfoContext(ctx, "bot authorized", "account", bot.Self.UserName)
 
 	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 30
+	updateConfig.Timeout = b.pollTimeout
 	updates := bot.GetUpdatesChan(updateConfig)
 
+	b.ready.Store(true)
+	defer b.ready.Store(false)
+
+	// Обработка не прерывается сигналом остановки: начатое обновление доводится до конца.
+	work := context.WithoutCancel(ctx)
+	pool := newChatWorkers(b.workers)
 	for {
 		select {
 		case update := <-updates:
-			if update.Message == nil {
-				continue
-			}
-			userID := update.Message.From.ID
-			text := strings.TrimSpace(update.Message.Text)
-			reply := b.handleMessage(ctx, userID, text)
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-			msg.ParseMode = b.parseMode
-			if _, err := bot.Send(msg); err != nil {
-				log.Printf("send message error: %v", err)
+			if update.Message != nil {
+				pool.Submit(update.Message.Chat.ID, func() { b.handleUpdate(work, bot, update) })
 			}
 		case <-ctx.Done():
-			return nil
+			b.ready.Store(false)
+			bot.StopReceivingUpdates()
+			return b.drain(work, bot, updates, pool)
+		}
+	}
+}
+
+// drain передает в пул обновления, уже полученные от Telegram, и ждет завершения
+// всех начатых обработок не дольше drainTimeout. Telegram считает полученные
+// обновления доставленными, поэтому при пропуске они были бы потеряны.
+func (b *TelegramBot) drain(ctx context.Context, bot *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel, pool *chatWorkers) error {
+	ctx, cancel := context.WithTimeout(ctx, b.drainTimeout)
+	defer cancel()
+	received := 0
+	for pending := true; pending; {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				pending = false
+			} else if update.Message != nil {
+				pool.Submit(update.Message.Chat.ID, func() { b.handleUpdate(ctx, bot, update) })
+				received++
+			}
+		default:
+			pending = false
+		}
+	}
+	if err := pool.Wait(ctx); err != nil {
+		return fmt.Errorf("drain deadline exceeded with %d update(s) unfinished", pool.Pending())
+	}
+	slog.Info("bot updates drained", "received", received)
+	return nil
+}
+
+// chatWorkers обрабатывает обновления параллельно, но не больше limit одновременно.
+// Обновления одного чата выполняются по очереди в порядке получения, поэтому долгая
+// команда, например загрузка файла /import, задерживает только свой чат.
+type chatWorkers struct {
+	slots  chan struct{}
+	queued chan struct{}
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	queues map[int64][]func()
+}
+
+// newChatWorkers создает пул на limit одновременных обработок. Очередь ограничена
+// 16 задачами на обработчик: при переполнении Submit ждет, и бот не забирает
+// новые обновления у Telegram.
+func newChatWorkers(limit int) *chatWorkers {
+	return &chatWorkers{
+		slots:  make(chan struct{}, limit),
+		queued: make(chan struct{}, limit*16),
+		queues: make(map[int64][]func()),
+	}
+}
+
+// Submit ставит задачу в очередь чата и запускает для него обработчик, если его нет.
+func (w *chatWorkers) Submit(chatID int64, task func()) {
+	w.queued <- struct{}{}
+	w.wg.Add(1)
+	w.mu.Lock()
+	queue, active := w.queues[chatID]
+	w.queues[chatID] = append(queue, task)
+	w.mu.Unlock()
+	if !active {
+		go w.run(chatID)
+	}
+}
+
+// run выполняет задачи чата, пока его очередь не опустеет.
+func (w *chatWorkers) run(chatID int64) {
+	for {
+		w.mu.Lock()
+		queue := w.queues[chatID]
+		if len(queue) == 0 {
+			delete(w.queues, chatID)
+			w.mu.Unlock()
+			return
+		}
+		task := queue[0]
+		w.queues[chatID] = queue[1:]
+		w.mu.Unlock()
+
+		w.slots <- struct{}{}
+		task()
+		<-w.slots
+		<-w.queued
+		w.wg.Done()
+	}
+}
+
+// Pending возвращает число поставленных и еще не завершенных задач.
+func (w *chatWorkers) Pending() int {
+	return len(w.queued)
+}
+
+// Wait ждет завершения всех поставленных задач или отмены ctx.
+func (w *chatWorkers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleUpdate обрабатывает одно обновление, отправляет ответ и пишет запись в лог.
+func (b *TelegramBot) handleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	ctx = withRequestID(ctx, fmt.Sprintf("tg-%d", update.UpdateID))
+	start := time.Now()
+	message := update.Message
+	command := commandName(messageText(message))
+	attrs := []any{
+		"chat_id", message.Chat.ID,
+		"user_id", message.From.ID,
+		"command", command,
+	}
+
+	ctx, span := appTracer.Start(ctx, "telegram update", SpanKindConsumer)
+	defer span.End()
+	span.SetAttr("telegram.update_id", update.UpdateID)
+	span.SetAttr("telegram.chat_id", message.Chat.ID)
+	span.SetAttr("telegram.command", command)
+
+	reply := b.replyFor(ctx, bot, message)
+	_, sendSpan := appTracer.Start(ctx, "telegram send", SpanKindClient)
+	_, err := bot.Send(reply)
+	sendSpan.RecordError(err)
+	sendSpan.End()
+	if err != nil {
+		span.RecordError(err)
+		appMetrics.TelegramSendErrs.Inc()
+		appMetrics.ObserveBotUpdate(command, "send_error")
+		slog.ErrorContext(ctx, "telegram send failed", append(attrs, "error", err)...)
+		return
+	}
+	appMetrics.ObserveBotUpdate(command, "ok")
+	slog.InfoContext(ctx, "telegram update", append(attrs, "duration", time.Since(start))...)
+}
+
+// commandName возвращает команду из текста сообщения для логов, не раскрывая текст заметок.
+func commandName(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "text"
+	}
+	return fields[0]
+}
+
+// messageText возвращает текст команды: у документа это подпись к нему.
+func messageText(message *tgbotapi.Message) string {
+	if message.Document != nil {
+		return message.Caption
+	}
+	return message.Text
+}
+
+// replyFor формирует ответ на сообщение: файл для команд, возвращающих документы, или текст.
+// Присланный документ загружается как файл заметок.
+func (b *TelegramBot) replyFor(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message) tgbotapi.Chattable {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	text := strings.TrimSpace(message.Text)
+
+	if message.Document != nil {
+		return b.handleImport(ctx, bot, chatID, userID, message.Document, strings.Fields(message.Caption))
+	}
+	if fields := strings.Fields(text); len(fields) > 0 {
+		switch fields[0] {
+		case "/graph":
+			return b.handleGraph(ctx, chatID, userID)
+		case "/export":
+			return b.handleExport(ctx, chatID, userID, fields)
+		case "/import":
+			return tgbotapi.NewMessage(chatID, importUsage)
+		}
+	}
+
+	// Ответы отправляются простым текстом: в тексте заметок и в виде связи depends_on
+	// встречаются символы разметки Markdown, и Telegram отклонил бы такое сообщение.
+	return tgbotapi.NewMessage(chatID, b.handleMessage(ctx, userID, text))
+}
+
+// handleGraph отправляет изображение графа заметок пользователя.
+// При ошибке возвращает текстовое сообщение.
+func (b *TelegramBot) handleGraph(ctx context.Context, chatID, userID int64) tgbotapi.Chattable {
+	if denied := b.checkAuthorized(ctx, userID); denied != "" {
+		return tgbotapi.NewMessage(chatID, denied)
+	}
+	graph, err := b.store.UserGraph(ctx, userID)
+	if err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось построить граф. Попробуйте позже."))
+	}
+	if len(graph.Notes) == 0 {
+		return tgbotapi.NewMessage(chatID, "У вас пока нет заметок. Добавьте через /add.")
+	}
+	picture, err := renderGraphPNG(graph)
+	if err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось нарисовать граф. Попробуйте позже."))
+	}
+	file := tgbotapi.FileBytes{Name: "graph.png", Bytes: picture}
+	caption := fmt.Sprintf("Заметок: %d, связей: %d", len(graph.Notes), len(graph.Links))
+	if !fitsTelegramPhoto(picture) {
+		// Telegram сжимает фотографии и отклоняет слишком большие, поэтому крупный граф уходит файлом.
+		document := tgbotapi.NewDocument(chatID, file)
+		document.Caption = caption
+		return document
+	}
+	photo := tgbotapi.NewPhoto(chatID, file)
+	photo.Caption = caption
+	return photo
+}
+
+// Ограничения Telegram для фотографий.
+const (
+	telegramPhotoMaxBytes = 10 << 20
+	telegramPhotoMaxSides = 10000
+	telegramPhotoMaxRatio = 20
+)
+
+// fitsTelegramPhoto сообщает, примет ли Telegram изображение как фотографию:
+// не больше 10 МБ, сумма сторон не больше 10000, соотношение сторон не больше 20.
+func fitsTelegramPhoto(picture []byte) bool {
+	config, err := png.DecodeConfig(bytes.NewReader(picture))
+	if err != nil || len(picture) > telegramPhotoMaxBytes {
+		return false
+	}
+	w, h := config.Width, config.Height
+	return w+h <= telegramPhotoMaxSides && w <= h*telegramPhotoMaxRatio && h <= w*telegramPhotoMaxRatio
+}
+
+// handleExport отправляет выгрузку заметок документом. Формат задается аргументом:
+// json (по умолчанию), markdown или csv.
+func (b *TelegramBot) handleExport(ctx context.Context, chatID, userID int64, fields []string) tgbotapi.Chattable {
+	if denied := b.checkAuthorized(ctx, userID); denied != "" {
+		return tgbotapi.NewMessage(chatID, denied)
+	}
+	format := ExportJSON
+	if len(fields) > 1 {
+		format = ExportFormat(strings.ToLower(fields[1]))
+	}
+	if len(fields) > 2 || !format.Valid() {
+		return tgbotapi.NewMessage(chatID, "Использование: /export [json|markdown|csv]")
+	}
+	dump, err := b.store.ExportUser(ctx, userID)
+	if err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось выгрузить заметки. Попробуйте позже."))
+	}
+	if len(dump.Notes) == 0 {
+		return tgbotapi.NewMessage(chatID, "У вас пока нет заметок. Добавьте через /add.")
+	}
+	var file bytes.Buffer
+	if err := WriteExport(&file, dump, format); err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось выгрузить заметки. Попробуйте позже."))
+	}
+	document := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: format.FileName(userID, dump.ExportedAt), Bytes: file.Bytes()})
+	document.Caption = fmt.Sprintf("Заметок: %d, связей: %d", len(dump.Notes), len(dump.Links))
+	return document
+}
+
+// importUsage объясняет, как загрузить файл заметок.
+const importUsage = "Пришлите файл с подписью /import или /import dry для проверки без изменений. " +
+	"Подходят выгрузка json, zip с файлами Markdown (например, хранилище Obsidian) и result.json из экспорта чата Telegram Desktop."
+
+// importDownloadTimeout ограничивает загрузку присланного файла с серверов Telegram.
+const importDownloadTimeout = time.Minute
+
+// handleImport загружает присланный документ в заметки пользователя. Подпись /import dry
+// только показывает, что будет загружено.
+func (b *TelegramBot) handleImport(ctx context.Context, bot *tgbotapi.BotAPI, chatID, userID int64, document *tgbotapi.Document, fields []string) tgbotapi.Chattable {
+	if len(fields) == 0 || fields[0] != "/import" || len(fields) > 2 || (len(fields) == 2 && fields[1] != "dry") {
+		return tgbotapi.NewMessage(chatID, importUsage)
+	}
+	if denied := b.checkAuthorized(ctx, userID); denied != "" {
+		return tgbotapi.NewMessage(chatID, denied)
+	}
+	if document.FileSize > maxImportBytes {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, errImportTooLarge, ""))
+	}
+	data, err := downloadDocument(ctx, bot, document.FileID)
+	if err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось получить файл. Попробуйте позже."))
+	}
+	summary, err := b.store.Import(ctx, userID, data, ImportAuto, len(fields) == 2)
+	if err != nil {
+		return tgbotapi.NewMessage(chatID, errorMessage(ctx, err, "Не удалось загрузить заметки. Попробуйте позже."))
+	}
+	return tgbotapi.NewMessage(chatID, importReport(summary))
+}
+
+// downloadDocument скачивает файл, присланный боту, не больше maxImportBytes.
+func downloadDocument(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, importDownloadTimeout)
+	defer cancel()
+	ctx, span := appTracer.Start(ctx, "telegram download", SpanKindClient)
+	defer span.End()
+
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("get file: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportBytes+1))
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	return data, nil
+}
+
+// importReport формирует отчет о загрузке для пользователя.
+func importReport(summary ImportSummary) string {
+	lines := []string{fmt.Sprintf("Загружено заметок: %d, связей: %d, пропущено связей: %d (формат %s).",
+		summary.Notes, summary.Links, summary.SkippedLinks, summary.Format)}
+	if summary.DryRun {
+		lines[0] = fmt.Sprintf("Проверка без изменений. Будет загружено заметок: %d, связей: %d, пропущено связей: %d (формат %s).",
+			summary.Notes, summary.Links, summary.SkippedLinks, summary.Format)
+	}
+	if len(summary.Preview) > 0 {
+		lines = append(lines, "", "Первые заметки:")
+		for _, preview := range summary.Preview {
+			lines = append(lines, "— "+previewText(preview, 40))
 		}
 	}
+	if len(summary.Warnings) > 0 {
+		lines = append(lines, "", "Предупреждения:")
+		lines = append(lines, summary.Warnings...)
+	}
+	if summary.DryRun {
+		lines = append(lines, "", "Чтобы загрузить, пришлите файл с подписью /import.")
+	}
+	return strings.Join(lines, "\n")
 }
 
 // handleMessage маршрутизирует команду пользователя.
@@ -88,23 +463,32 @@ func (b *TelegramBot) handleLogin(ctx context.Context, userID int64, fields []st
 	if len(fields) < 3 {
 		return "Используйте /login <логин> <пароль>"
 	}
-	if fields[1] != b.login || fields[2] != b.password {
+	creds := b.credentials.Load()
+	if creds.User == "" || fields[1] != creds.User || fields[2] != creds.Password {
 		return "Неверный логин или пароль."
 	}
 	if err := b.store.AuthorizeUser(ctx, userID); err != nil {
-		return "Не удалось сохранить авторизацию. Попробуйте позже."
+		return errorMessage(ctx, err, "Не удалось сохранить авторизацию. Попробуйте позже.")
 	}
 	return "Авторизация успешна. Теперь можно работать с заметками."
 }
 
-// handleAuthorized выполняет команды, требующие авторизации.
-func (b *TelegramBot) handleAuthorized(ctx context.Context, userID int64, command, text string, fields []string) string {
+// checkAuthorized возвращает сообщение об отказе или пустую строку, если пользователь авторизован.
+func (b *TelegramBot) checkAuthorized(ctx context.Context, userID int64) string {
 	authorized, err := b.store.IsUserAuthorized(ctx, userID)
 	if err != nil {
-		return "Не удалось проверить авторизацию."
+		return errorMessage(ctx, err, "Не удалось проверить авторизацию.")
 	}
 	if !authorized {
-		return "Сначала выполните /login <логин> <пароль>."
+		return errorMessage(ctx, errNotAuthorized, "")
+	}
+	return ""
+}
+
+// handleAuthorized выполняет команды, требующие авторизации.
+func (b *TelegramBot) handleAuthorized(ctx context.Context, userID int64, command, text string, fields []string) string {
+	if denied := b.checkAuthorized(ctx, userID); denied != "" {
+		return denied
 	}
 
 	switch command {
@@ -115,20 +499,20 @@ func (b *TelegramBot) handleAuthorized(ctx context.Context, userID int64, comman
 		}
 		note, err := b.store.AddNote(ctx, userID, payload)
 		if err != nil {
-			return "Не удалось сохранить заметку. Попробуйте позже."
+			return errorMessage(ctx, err, "Не удалось сохранить заметку. Попробуйте позже.")
 		}
 		return fmt.Sprintf("Заметка #%d сохранена.", note.ID)
 	case "/list":
 		notes, err := b.store.ListNotes(ctx, userID)
 		if err != nil {
-			return "Не удалось получить заметки. Попробуйте позже."
+			return errorMessage(ctx, err, "Не удалось получить заметки. Попробуйте позже.")
 		}
 		if len(notes) == 0 {
 			return "У вас пока нет заметок. Добавьте через /add."
 		}
 		links, err := b.store.ListLinks(ctx, userID)
 		if err != nil {
-			return "Не удалось получить связи между заметками."
+			return errorMessage(ctx, err, "Не удалось получить связи между заметками.")
 		}
 		return formatNotesWithLinks(notes, links)
 	case "/delete":
@@ -139,19 +523,23 @@ func (b *TelegramBot) handleAuthorized(ctx context.Context, userID int64, comman
 		if err != nil || id <= 0 {
 			return "Номер заметки должен быть числом: /delete 2"
 		}
-		deleted, err := b.store.DeleteNote(ctx, userID, id)
+		deleted, err := b.store.DeleteNote(ctx, userID, id, anyVersion)
 		if err != nil {
-			return "Не удалось удалить заметку. Попробуйте позже."
+			return errorMessage(ctx, err, "Не удалось удалить заметку. Попробуйте позже.")
 		}
 		if !deleted {
-			return "Заметка с таким номером не найдена."
+			return errorMessage(ctx, errNoteNotFound, "")
 		}
 		return "Заметка помечена как удаленная."
 	case "/clear":
 		if err := b.store.ClearNotes(ctx, userID); err != nil {
-			return "Не удалось очистить заметки. Попробуйте позже."
+			return errorMessage(ctx, err, "Не удалось очистить заметки. Попробуйте позже.")
 		}
 		return "Все заметки помечены как удаленные."
+	case "/note":
+		return b.handleNoteView(ctx, userID, fields)
+	case "/path":
+		return b.handlePath(ctx, userID, fields)
 	case "/link":
 		return b.handleLinkCreate(ctx, userID, fields)
 	case "/link_edit":
@@ -166,7 +554,7 @@ func (b *TelegramBot) handleAuthorized(ctx context.Context, userID int64, comman
 // handleLinkCreate создает связь между заметками пользователя.
 func (b *TelegramBot) handleLinkCreate(ctx context.Context, userID int64, fields []string) string {
 	if len(fields) < 3 {
-		return "Укажите две заметки: /link 1 2"
+		return "Укажите две заметки: /link 1 2 [reference|related|depends_on]"
 	}
 	fromID, err := strconv.Atoi(fields[1])
 	if err != nil || fromID <= 0 {
@@ -176,13 +564,76 @@ func (b *TelegramBot) handleLinkCreate(ctx context.Context, userID int64, fields
 	if err != nil || toID <= 0 {
 		return "Второй номер должен быть числом: /link 1 2"
 	}
-	link, err := b.store.AddLink(ctx, userID, fromID, toID)
+	kind := LinkKindReference
+	if len(fields) > 3 {
+		kind = LinkKind(fields[3])
+	}
+	link, err := b.store.AddLink(ctx, userID, fromID, toID, kind)
 	if err != nil {
-		return "Не удалось добавить связь. Попробуйте позже."
+		return errorMessage(ctx, err, "Не удалось добавить связь. Попробуйте позже.")
 	}
 	return fmt.Sprintf("Связь #%d добавлена.", link.ID)
 }
 
+// handleNoteView показывает заметку вместе с исходящими и входящими связями.
+func (b *TelegramBot) handleNoteView(ctx context.Context, userID int64, fields []string) string {
+	if len(fields) < 2 {
+		return "Укажите номер заметки: /note 2"
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil || id <= 0 {
+		return "Номер заметки должен быть числом: /note 2"
+	}
+	note, found, err := b.store.GetNote(ctx, userID, id)
+	if err != nil {
+		return errorMessage(ctx, err, "Не удалось получить заметку. Попробуйте позже.")
+	}
+	if !found {
+		return errorMessage(ctx, errNoteNotFound, "")
+	}
+	outgoing, err := b.store.ListLinksForNote(ctx, userID, id)
+	if err != nil {
+		return errorMessage(ctx, err, "Не удалось получить связи заметки.")
+	}
+	incoming, err := b.store.ListBacklinks(ctx, userID, id)
+	if err != nil {
+		return errorMessage(ctx, err, "Не удалось получить связи заметки.")
+	}
+
+	ids := make([]uint, 0, len(outgoing)+len(incoming))
+	for _, link := range append(append([]NoteLink{}, outgoing...), incoming...) {
+		ids = append(ids, link.OtherID(note.ID))
+	}
+	linked, err := b.store.ListNotesByIDs(ctx, userID, ids)
+	if err != nil {
+		return errorMessage(ctx, err, "Не удалось получить связанные заметки.")
+	}
+	return formatNoteView(note, outgoing, incoming, linked)
+}
+
+// handlePath показывает кратчайшую цепочку связей между двумя заметками.
+func (b *TelegramBot) handlePath(ctx context.Context, userID int64, fields []string) string {
+	if len(fields) < 3 {
+		return "Укажите две заметки: /path 1 5"
+	}
+	fromID, err := strconv.Atoi(fields[1])
+	if err != nil || fromID <= 0 {
+		return "Первый номер должен быть числом: /path 1 5"
+	}
+	toID, err := strconv.Atoi(fields[2])
+	if err != nil || toID <= 0 {
+		return "Второй номер должен быть числом: /path 1 5"
+	}
+	path, found, err := b.store.ShortestPath(ctx, userID, fromID, toID, false)
+	if err != nil {
+		return errorMessage(ctx, err, "Не удалось найти цепочку. Попробуйте позже.")
+	}
+	if !found {
+		return "Цепочка между заметками не найдена."
+	}
+	return formatPath(path)
+}
+
 // handleLinkEdit редактирует существующую связь.
 func (b *TelegramBot) handleLinkEdit(ctx context.Context, userID int64, fields []string) string {
 	if len(fields) < 3 {
@@ -196,12 +647,12 @@ func (b *TelegramBot) handleLinkEdit(ctx context.Context, userID int64, fields [
 	if err != nil || newToID <= 0 {
 		return "new_to_id должен быть положительным числом"
 	}
-	updated, err := b.store.UpdateLink(ctx, userID, uint(linkID), uint(newToID))
+	_, updated, err := b.store.UpdateLink(ctx, userID, uint(linkID), uint(newToID), anyVersion)
 	if err != nil {
-		return "Не удалось обновить связь."
+		return errorMessage(ctx, err, "Не удалось обновить связь.")
 	}
 	if !updated {
-		return "Связь не найдена."
+		return errorMessage(ctx, errLinkNotFound, "")
 	}
 	return "Связь обновлена."
 }
@@ -215,38 +666,138 @@ func (b *TelegramBot) handleLinkDelete(ctx context.Context, userID int64, fields
 	if err != nil || linkID <= 0 {
 		return "link_id должен быть положительным числом"
 	}
-	deleted, err := b.store.DeleteLink(ctx, userID, uint(linkID))
+	deleted, err := b.store.DeleteLink(ctx, userID, uint(linkID), anyVersion)
 	if err != nil {
-		return "Не удалось удалить связь."
+		return errorMessage(ctx, err, "Не удалось удалить связь.")
 	}
 	if !deleted {
-		return "Связь не найдена."
+		return errorMessage(ctx, errLinkNotFound, "")
 	}
 	return "Связь удалена."
 }
 
-// formatNotesWithLinks формирует список заметок с указанием связей.
+// errorMessages задает сообщения пользователю для кодов ошибок предметной области.
+var errorMessages = map[string]string{
+	"duplicate_link":        "Такая связь уже существует.",
+	"self_link":             "Нельзя связать заметку саму с собой.",
+	"invalid_link_kind":     "Вид связи должен быть одним из: reference, related, depends_on",
+	"note_not_found":        "Заметка с таким номером не найдена или удалена.",
+	"link_not_found":        "Связь не найдена.",
+	"not_authorized":        "Сначала выполните /login <логин> <пароль>.",
+	"graph_image_too_large": "Граф слишком большой для изображения. Выгрузите его через /export или GET /v1/graph?format=svg.",
+	"graph_too_large":       "Граф слишком большой для этого запроса. Уменьшите глубину.",
+	"invalid_import":        "Не удалось разобрать файл. Подходят выгрузка json, zip с файлами Markdown и result.json из Telegram Desktop.",
+	"import_too_large":      "Файл слишком большой: не больше 20 МБ и 10000 заметок.",
+}
+
+// errorMessage подбирает сообщение пользователю по ошибке: сначала по коду,
+// затем по виду ошибки. Внутренние ошибки пишутся в лог, а пользователь видит fallback.
+func errorMessage(ctx context.Context, err error, fallback string) string {
+	var domain *DomainError
+	if errors.As(err, &domain) {
+		if message, ok := errorMessages[domain.Code]; ok {
+			return message
+		}
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "Ничего не найдено."
+	case errors.Is(err, ErrValidation):
+		return "Проверьте параметры команды. Используйте /help."
+	case errors.Is(err, ErrConflict):
+		return "Операция противоречит текущему состоянию заметок."
+	case errors.Is(err, ErrForbidden):
+		return "Недостаточно прав для этой операции."
+	}
+	slog.ErrorContext(ctx, "bot command failed", "error", err)
+	return fallback
+}
+
+// formatNotesWithLinks формирует список заметок, под каждой из которых перечислены
+// связанные заметки с заголовками.
 func formatNotesWithLinks(notes []Note, links []NoteLink) string {
 	linksMap := make(map[uint][]uint)
 	for _, link := range links {
 		linksMap[link.FromID] = append(linksMap[link.FromID], link.ToID)
+		if link.Kind.Symmetric() {
+			linksMap[link.ToID] = append(linksMap[link.ToID], link.FromID)
+		}
 	}
 	for id := range linksMap {
 		sort.Slice(linksMap[id], func(i, j int) bool { return linksMap[id][i] < linksMap[id][j] })
 	}
+	titles := make(map[uint]string, len(notes))
+	for _, note := range notes {
+		titles[note.ID] = noteTitle(note.Text, 40)
+	}
 
 	lines := make([]string, 0, len(notes)+1)
 	lines = append(lines, "Ваши заметки:")
 	for _, note := range notes {
-		line := fmt.Sprintf("%d. %s", note.ID, note.Text)
-		if linked := linksMap[note.ID]; len(linked) > 0 {
-			line = fmt.Sprintf("%s (связи: %s)", line, joinUints(linked))
+		lines = append(lines, fmt.Sprintf("%d. %s", note.ID, note.Text))
+		for _, id := range linksMap[note.ID] {
+			title, ok := titles[id]
+			if !ok {
+				title = "(заметка удалена)"
+			}
+			lines = append(lines, fmt.Sprintf("   → %d. %s", id, title))
 		}
-		lines = append(lines, line)
 	}
 	return strings.Join(lines, "\n")
 }
 
+// formatNoteView формирует карточку заметки с исходящими и входящими связями.
+func formatNoteView(note Note, outgoing, incoming []NoteLink, linked []Note) string {
+	texts := make(map[uint]string, len(linked))
+	for _, n := range linked {
+		texts[n.ID] = n.Text
+	}
+
+	lines := []string{fmt.Sprintf("Заметка #%d", note.ID), note.Text}
+	appendSection := func(title string, links []NoteLink) {
+		lines = append(lines, "", title)
+		if len(links) == 0 {
+			lines = append(lines, "нет")
+			return
+		}
+		for _, link := range links {
+			otherID := link.OtherID(note.ID)
+			preview, ok := texts[otherID]
+			if !ok {
+				preview = "(заметка удалена)"
+			}
+			lines = append(lines, fmt.Sprintf("%d. [%s] %s", otherID, link.Kind, noteTitle(preview, 40)))
+		}
+	}
+	appendSection("Исходящие связи:", outgoing)
+	appendSection("Входящие связи:", incoming)
+	return strings.Join(lines, "\n")
+}
+
+// formatPath формирует цепочку заметок по шагам.
+func formatPath(path []Note) string {
+	lines := make([]string, 0, len(path)+1)
+	lines = append(lines, fmt.Sprintf("Цепочка из %d шагов:", len(path)-1))
+	for i, note := range path {
+		prefix := "→"
+		if i == 0 {
+			prefix = "•"
+		}
+		lines = append(lines, fmt.Sprintf("%s %d. %s", prefix, note.ID, noteTitle(note.Text, 40)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// previewText обрезает текст заметки до заданного числа символов.
+func previewText(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
+
 // joinUints форматирует список чисел в строку.
 func joinUints(values []uint) string {
 	parts := make([]string, 0, len(values))
@@ -268,7 +819,12 @@ func helpMessage() string {
 		"/login <логин> <пароль> — авторизация",
 		"/add <текст> — добавить заметку",
 		"/list — список заметок",
-		"/link <id1> <id2> — создать связь",
+		"/note <номер> — заметка со связями",
+		"/path <id1> <id2> — кратчайшая цепочка связей",
+		"/graph — изображение графа заметок",
+		"/export [json|markdown|csv] — выгрузить заметки файлом",
+		"/import [dry] — подпись к файлу для загрузки заметок (json, zip с Markdown, result.json Telegram)",
+		"/link <id1> <id2> [вид] — создать связь (reference, related, depends_on)",
 		"/link_edit <link_id> <new_to_id> — редактировать связь",
 		"/link_delete <link_id> — удалить связь",
 		"/delete <номер> — пометить заметку удаленной",
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHelpReplyIsPlainText(t *testing.T) {
+	bot := NewTelegramBot(&NotesStore{}, "token", "login", "password")
+	message := &tgbotapi.Message{
+		Text: "/help",
+		From: &tgbotapi.User{ID: 1},
+		Chat: &tgbotapi.Chat{ID: 1},
+	}
+	reply, ok := bot.replyFor(context.Background(), nil, message).(tgbotapi.MessageConfig)
+	if !ok {
+		t.Fatalf("reply = %T, want a text message", reply)
+	}
+	// В depends_on непарный символ _, который Telegram в режиме Markdown не принимает.
+	if !strings.Contains(reply.Text, "depends_on") || reply.ParseMode != "" {
+		t.Errorf("parse mode = %q for text:\n%s", reply.ParseMode, reply.Text)
+	}
+}
+
+func TestChatWorkersKeepChatOrder(t *testing.T) {
+	pool := newChatWorkers(4)
+	var mu sync.Mutex
+	var got []int
+	for i := range 50 {
+		pool.Submit(1, func() {
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		})
+	}
+	if err := pool.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 50 || !slices.IsSorted(got) {
+		t.Errorf("chat tasks ran out of order: %v", got)
+	}
+}
+
+func TestChatWorkersSlowChatDoesNotBlockOthers(t *testing.T) {
+	pool := newChatWorkers(2)
+	release := make(chan struct{})
+	pool.Submit(1, func() { <-release })
+
+	done := make(chan struct{})
+	pool.Submit(2, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second chat waits for the first one")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Wait(ctx); err == nil || pool.Pending() != 1 {
+		t.Errorf("Wait = %v with %d pending, want a deadline and one pending task", err, pool.Pending())
+	}
+	close(release)
+	if err := pool.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChatWorkersLimitConcurrency(t *testing.T) {
+	pool := newChatWorkers(3)
+	var running, peak atomic.Int32
+	for chat := range 20 {
+		pool.Submit(int64(chat), func() {
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	if err := pool.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", peak.Load())
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cliCommand описывает подкоманду бинарника.
+type cliCommand struct {
+	Name  string
+	Usage string
+	Run   func(config Config, args []string) error
+}
+
+// cliCommands возвращает список поддерживаемых подкоманд.
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{Name: "serve", Usage: "run components selected by MODE (all, api or bot)", Run: func(config Config, args []string) error {
+			return runServeCommand("serve", config, args, "")
+		}},
+		{Name: "serve-api", Usage: "run HTTP API only", Run: func(config Config, args []string) error {
+			return runServeCommand("serve-api", config, args, ServeModeAPI)
+		}},
+		{Name: "serve-bot", Usage: "run Telegram bot only", Run: func(config Config, args []string) error {
+			return runServeCommand("serve-bot", config, args, ServeModeBot)
+		}},
+		{Name: "migrate", Usage: "migrate up|down [-steps N]|status", Run: runMigrate},
+		{Name: "check", Usage: "check link integrity [-repair]", Run: runCheck},
+		{Name: "user", Usage: "user add|list|revoke — manage authorized bot users", Run: runUser},
+		{Name: "token", Usage: "token issue|list|revoke — manage HTTP API tokens", Run: runToken},
+		{Name: "export", Usage: "export -user ID [-format json|markdown|csv] [-out FILE] — dump notes and links", Run: runExport},
+		{Name: "import", Usage: "import -user ID [-format auto|json|markdown|telegram] [-in FILE] [-dry-run] — load notes and links", Run: runImport},
+		{Name: "openapi", Usage: "openapi [-check] — print the API specification or check routes against it", Run: runOpenAPI},
+		{Name: "purge", Usage: "purge [-older-than D] [-user ID] [-dry-run] — remove deleted notes", Run: runPurge},
+	}
+}
+
+// runCLI выбирает подкоманду по первому аргументу. По умолчанию выполняется serve.
+func runCLI(args []string) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	for _, command := range cliCommands() {
+		if command.Name == name {
+			config, err := LoadConfig()
+			if err != nil {
+				return err
+			}
+			setupLogging(os.Stderr, config.LogFormat, config.LogLevel)
+			return command.Run(config, args)
+		}
+	}
+	printUsage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// printUsage выводит список подкоманд.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: notes <command> [flags]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, command := range cliCommands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", command.Name, command.Usage)
+	}
+	_ = tw.Flush()
+}
+
+// newCommandFlags создает набор флагов подкоманды, которые переопределяют значения из .env.
+// Пароли и токен бота флагами не задаются: аргументы командной строки видны в ps
+// и истории оболочки, поэтому секреты передаются только через окружение или *_FILE.
+func newCommandFlags(name string, config *Config) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&config.DatabaseURL, "database-url", config.DatabaseURL, "PostgreSQL connection URL (DATABASE_URL)")
+	flags.StringVar(&config.HTTPAddr, "http-addr", config.HTTPAddr, "HTTP listen address (HTTP_ADDR)")
+	flags.StringVar(&config.APIUser, "api-user", config.APIUser, "HTTP API login (API_USER)")
+	flags.StringVar(&config.BotLogin, "bot-login", config.BotLogin, "bot login (BOT_LOGIN)")
+	flags.Func("link-policy", "link delete policy: hide, keep or cascade (LINK_DELETE_POLICY)", func(value string) error {
+		config.LinkPolicy = LinkDeletePolicy(value)
+		return nil
+	})
+	return flags
+}
+
+// withStore открывает хранилище, выполняет fn и закрывает соединение.
+// Если migrate задан, перед fn применяются миграции.
+func withStore(config Config, migrate bool, fn func(ctx context.Context, store *NotesStore) error) error {
+	store, err := OpenNotesStore(config.DatabaseURL, config.LinkPolicy)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if migrate {
+		if err := store.Migrate(ctx); err != nil {
+			return errors.Join(err, store.Close())
+		}
+	}
+	return errors.Join(fn(ctx, store), store.Close())
+}
+
+// subcommand отделяет вложенную подкоманду (например, add в user add) от флагов.
+func subcommand(group string, args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("%s: subcommand is required", group)
+	}
+	return args[0], args[1:], nil
+}
+
+// runServeCommand разбирает флаги и запускает компоненты. Непустой mode
+// переопределяет режим из конфигурации.
+func runServeCommand(name string, config Config, args []string, mode ServeMode) error {
+	if err := parseServeFlags(name, &config, args, mode); err != nil {
+		return err
+	}
+	reload := func() (Config, error) {
+		updated, err := LoadConfig()
+		if err != nil {
+			return Config{}, err
+		}
+		return updated, parseServeFlags(name, &updated, args, mode)
+	}
+	return runServe(config, reload)
+}
+
+// parseServeFlags применяет флаги команды запуска к конфигурации.
+// Используется и при перезагрузке, чтобы флаги продолжали переопределять окружение.
+func parseServeFlags(name string, config *Config, args []string, mode ServeMode) error {
+	flags := newCommandFlags(name, config)
+	if mode == "" {
+		flags.Func("mode", "components to run: all, api or bot (MODE)", func(value string) error {
+			config.Mode = ServeMode(value)
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if mode != "" {
+		config.Mode = mode
+	}
+	return nil
+}
+
+// runCheck проверяет целостность связей и при флаге -repair исправляет найденные проблемы.
+// Миграции не выполняются, чтобы проверку можно было запустить до создания ограничений.
+func runCheck(config Config, args []string) error {
+	flags := newCommandFlags("check", &config)
+	repair := flags.Bool("repair", false, "repair found problems")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withStore(config, false, func(ctx context.Context, store *NotesStore) error {
+		report, err := store.CheckConsistency(ctx, *repair)
+		if err != nil {
+			return err
+		}
+		fmt.Println(report)
+		return nil
+	})
+}
+
+// runMigrate выполняет команду migrate up|down|status.
+func runMigrate(config Config, args []string) error {
+	action, args, err := subcommand("migrate", args)
+	if err != nil {
+		return err
+	}
+	flags := newCommandFlags("migrate "+action, &config)
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withStore(config, false, func(ctx context.Context, store *NotesStore) error {
+		switch action {
+		case "up":
+			if err := store.Migrate(ctx); err != nil {
+				return err
+			}
+			current, _, err := store.SchemaVersion(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("schema is at version %d\n", current)
+		case "down":
+			reverted, err := store.MigrateDown(ctx, *steps)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("reverted %d migration(s)\n", reverted)
+		case "status":
+			statuses, err := store.MigrationStatus(ctx)
+			if err != nil {
+				return err
+			}
+			for _, status := range statuses {
+				state := "pending"
+				if status.AppliedAt != nil {
+					state = "applied " + status.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%04d %-24s %s\n", status.Version, status.Name, state)
+			}
+		default:
+			return fmt.Errorf("unknown migrate command %q", action)
+		}
+		return nil
+	})
+}
+
+// runUser управляет авторизованными пользователями бота.
+func runUser(config Config, args []string) error {
+	action, args, err := subcommand("user", args)
+	if err != nil {
+		return err
+	}
+	flags := newCommandFlags("user "+action, &config)
+	userID := flags.Int64("id", 0, "Telegram user ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if action != "list" && *userID <= 0 {
+		return errInvalidUserID
+	}
+
+	return withStore(config, true, func(ctx context.Context, store *NotesStore) error {
+		switch action {
+		case "add":
+			if err := store.AuthorizeUser(ctx, *userID); err != nil {
+				return err
+			}
+			fmt.Printf("user %d authorized\n", *userID)
+		case "list":
+			users, err := store.ListAuthorizedUsers(ctx)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				fmt.Println(user.UserID)
+			}
+		case "revoke":
+			revoked, err := store.RevokeUser(ctx, *userID)
+			if err != nil {
+				return err
+			}
+			if !revoked {
+				return fmt.Errorf("user %d is not authorized", *userID)
+			}
+			fmt.Printf("user %d revoked\n", *userID)
+		default:
+			return fmt.Errorf("unknown user command %q", action)
+		}
+		return nil
+	})
+}
+
+// runToken управляет токенами доступа к HTTP API.
+func runToken(config Config, args []string) error {
+	action, args, err := subcommand("token", args)
+	if err != nil {
+		return err
+	}
+	flags := newCommandFlags("token "+action, &config)
+	name := flags.String("name", "", "token name")
+	id := flags.Uint("id", 0, "token ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withStore(config, true, func(ctx context.Context, store *NotesStore) error {
+		switch action {
+		case "issue":
+			if *name == "" {
+				return errors.New("token issue: -name is required")
+			}
+			plain, token, err := store.IssueAPIToken(ctx, *name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("token %d (%s): %s\n", token.ID, token.Name, plain)
+			fmt.Println("store it now, it cannot be shown again")
+		case "list":
+			tokens, err := store.ListAPITokens(ctx)
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tNAME\tCREATED\tREVOKED")
+			for _, token := range tokens {
+				revoked := "-"
+				if token.RevokedAt != nil {
+					revoked = token.RevokedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", token.ID, token.Name, token.CreatedAt.Format(time.RFC3339), revoked)
+			}
+			return tw.Flush()
+		case "revoke":
+			revoked, err := store.RevokeAPIToken(ctx, *id)
+			if err != nil {
+				return err
+			}
+			if !revoked {
+				return fmt.Errorf("token %d not found or already revoked", *id)
+			}
+			fmt.Printf("token %d revoked\n", *id)
+		default:
+			return fmt.Errorf("unknown token command %q", action)
+		}
+		return nil
+	})
+}
+
+// runExport выгружает заметки и связи пользователя в JSON, zip с Markdown или CSV.
+func runExport(config Config, args []string) error {
+	flags := newCommandFlags("export", &config)
+	userID := flags.Int64("user", 0, "Telegram user ID")
+	out := flags.String("out", "-", "output file, - for stdout")
+	format := flags.String("format", string(ExportJSON), "json, markdown (zip) or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return errInvalidUserID
+	}
+	if !ExportFormat(*format).Valid() {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	return withStore(config, true, func(ctx context.Context, store *NotesStore) error {
+		dump, err := store.ExportUser(ctx, *userID)
+		if err != nil {
+			return err
+		}
+		if *out == "-" {
+			return WriteExport(os.Stdout, dump, ExportFormat(*format))
+		}
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := WriteExport(file, dump, ExportFormat(*format)); err != nil {
+			file.Close()
+			return err
+		}
+		// Ошибка закрытия означает, что выгрузка записана не полностью.
+		return file.Close()
+	})
+}
+
+// runImport загружает заметки и связи пользователя из выгрузки, zip с Markdown
+// или экспорта чата Telegram.
+func runImport(config Config, args []string) error {
+	flags := newCommandFlags("import", &config)
+	userID := flags.Int64("user", 0, "Telegram user ID to import notes into")
+	in := flags.String("in", "-", "input file, - for stdin")
+	format := flags.String("format", string(ImportAuto), "auto, json, markdown (zip) or telegram (result.json)")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return errInvalidUserID
+	}
+	if !ImportFormat(*format).Valid() {
+		return fmt.Errorf("unknown import format %q", *format)
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxImportBytes+1))
+	if err != nil {
+		return err
+	}
+
+	return withStore(config, true, func(ctx context.Context, store *NotesStore) error {
+		summary, err := store.Import(ctx, *userID, data, ImportFormat(*format), *dryRun)
+		if err != nil {
+			return err
+		}
+		verb := "imported"
+		if *dryRun {
+			verb = "would import"
+		}
+		fmt.Printf("%s %d note(s), %d link(s) from %s, skipped %d link(s)\n", verb, summary.Notes, summary.Links, summary.Format, summary.SkippedLinks)
+		for _, preview := range summary.Preview {
+			fmt.Printf("  %s\n", preview)
+		}
+		for _, warning := range summary.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+		}
+		return nil
+	})
+}
+
+// runPurge физически удаляет заметки, давно помеченные удаленными.
+func runPurge(config Config, args []string) error {
+	flags := newCommandFlags("purge", &config)
+	olderThan := flags.Duration("older-than", 30*24*time.Hour, "purge notes deleted earlier than this")
+	userID := flags.Int64("user", 0, "purge only this user, 0 for everyone")
+	dryRun := flags.Bool("dry-run", false, "only count notes to purge")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withStore(config, true, func(ctx context.Context, store *NotesStore) error {
+		count, err := store.PurgeDeletedNotes(ctx, *userID, time.Now().Add(-*olderThan), *dryRun)
+		if err != nil {
+			return err
+		}
+		verb := "purged"
+		if *dryRun {
+			verb = "would purge"
+		}
+		fmt.Printf("%s %d note(s)\n", verb, count)
+		return nil
+	})
+}
+
+// runOpenAPI выводит спецификацию API или с -check сверяет с ней маршруты обработчика.
+// Проверка не требует базы данных и подходит для CI.
+func runOpenAPI(config Config, args []string) error {
+	flags := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	check := flags.Bool("check", false, "check that every operation in the specification is routed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*check {
+		_, err := os.Stdout.Write(openAPIDocument)
+		return err
+	}
+
+	// Журнал запросов проверки не нужен.
+	logLevel.Set(slog.LevelWarn)
+	api := NewAPI(&NotesStore{}, "contract", "check")
+	api.EnableHealth(&Health{})
+	problems := CheckContract(api, "contract", "check")
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("openapi contract: %d problem(s)", len(problems))
+	}
+	fmt.Println("openapi contract: ok")
+	return nil
+}
@@ -1,44 +1,469 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
-// Config хранит настройки приложения из переменных окружения.
+// Config хранит настройки приложения из переменных окружения и файла конфигурации.
 type Config struct {
-	BotToken    string
-	DatabaseURL string
-	HTTPAddr    string
-	APIUser     string
-	APIPassword string
-	BotLogin    string
-	BotPassword string
+	BotToken         string
+	DatabaseURL      string
+	HTTPAddr         string
+	APIUser          string
+	APIPassword      string
+	BotLogin         string
+	BotPassword      string
+	LinkPolicy       LinkDeletePolicy
+	Mode             ServeMode
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	ShutdownTimeout  time.Duration
+	DrainDelay       time.Duration
+	BotPollTimeout   int
+	BotDebug         bool
+	BotWorkers       int
+	LogLevel         slog.Level
+	LogFormat        LogFormat
+	MaxBodyBytes     int64
+	IdempotencyTTL   time.Duration
+	EventsHeartbeat  time.Duration
+	EventsListen     bool
+	// WebhookPollInterval, WebhookTimeout, WebhookBackoff и WebhookMaxAttempts настраивают отправку вебхуков.
+	WebhookPollInterval time.Duration
+	WebhookTimeout      time.Duration
+	WebhookBackoff      time.Duration
+	WebhookMaxAttempts  int
+	MetricsToken        string
+	TraceExporter       TraceExporter
+	TraceEndpoint       string
+	TraceServiceName    string
+	TraceSampleRatio    float64
 }
 
 // LoadConfig загружает переменные из .env в корне проекта и возвращает конфигурацию.
-func LoadConfig() Config {
-	if err := godotenv.Load(".env"); err != nil {
-		log.Printf(".env not loaded: %v", err)
+// Значения берутся по приоритету: переменные окружения, затем .env, затем файл
+// из CONFIG_FILE, затем значения по умолчанию. Окружение процесса не изменяется,
+// поэтому повторный вызов видит новое содержимое .env. Для секретов поддерживаются варианты с суффиксом _FILE.
+// Ошибка содержит сразу все найденные проблемы.
+func LoadConfig() (Config, error) {
+	loader := &configLoader{}
+	dotenv, err := godotenv.Read(".env")
+	if err != nil {
+		slog.Warn(".env not loaded", "error", err)
+	}
+	loader.dotenv = dotenv
+	if path, ok := loader.lookup("CONFIG_FILE"); ok {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid configuration: %w", err)
+		}
+		loader.file = values
+	}
+
+	config := Config{
+		BotToken:            loader.secret("BOT_TOKEN"),
+		DatabaseURL:         loader.secret("DATABASE_URL"),
+		HTTPAddr:            loader.string("HTTP_ADDR", ":8080"),
+		APIUser:             loader.string("API_USER", ""),
+		APIPassword:         loader.secret("API_PASSWORD"),
+		BotLogin:            loader.string("BOT_LOGIN", ""),
+		BotPassword:         loader.secret("BOT_PASSWORD"),
+		LinkPolicy:          LinkDeletePolicy(loader.string("LINK_DELETE_POLICY", string(LinkPolicyHide))),
+		Mode:                ServeMode(loader.string("MODE", string(ServeModeAll))),
+		HTTPReadTimeout:     loader.duration("HTTP_READ_TIMEOUT", 15*time.Second),
+		HTTPWriteTimeout:    loader.duration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		ShutdownTimeout:     loader.duration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DrainDelay:          loader.duration("DRAIN_DELAY", 5*time.Second),
+		BotPollTimeout:      loader.int("BOT_POLL_TIMEOUT", 30),
+		BotDebug:            loader.bool("BOT_DEBUG", false),
+		BotWorkers:          loader.int("BOT_WORKERS", 8),
+		LogLevel:            loader.logLevel("LOG_LEVEL", slog.LevelInfo),
+		LogFormat:           LogFormat(loader.string("LOG_FORMAT", string(LogFormatText))),
+		MaxBodyBytes:        int64(loader.int("MAX_BODY_BYTES", 1<<20)),
+		IdempotencyTTL:      loader.duration("IDEMPOTENCY_TTL", 24*time.Hour),
+		EventsHeartbeat:     loader.duration("EVENTS_HEARTBEAT", 15*time.Second),
+		EventsListen:        loader.bool("EVENTS_LISTEN", false),
+		WebhookPollInterval: loader.duration("WEBHOOK_POLL_INTERVAL", time.Second),
+		WebhookTimeout:      loader.duration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookBackoff:      loader.duration("WEBHOOK_BACKOFF", 30*time.Second),
+		WebhookMaxAttempts:  loader.int("WEBHOOK_MAX_ATTEMPTS", 8),
+		MetricsToken:        loader.secret("METRICS_TOKEN"),
+		TraceExporter:       TraceExporter(loader.string("TRACE_EXPORTER", string(TraceExporterNone))),
+		TraceEndpoint:       loader.string("TRACE_OTLP_ENDPOINT", "http://localhost:4318"),
+		TraceServiceName:    loader.string("TRACE_SERVICE_NAME", "notes"),
+		TraceSampleRatio:    loader.float("TRACE_SAMPLE_RATIO", 1),
+	}
+	loader.checkUnknownKeys()
+	if len(loader.problems) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration: %w", errors.Join(loader.problems...))
+	}
+	return config, nil
+}
+
+// Validate проверяет настройки, необходимые для запуска сервисов в выбранном режиме,
+// и возвращает сразу все найденные проблемы.
+func (c Config) Validate() error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.DatabaseURL == "" {
+		add("DATABASE_URL is required")
+	}
+	if !c.Mode.Valid() {
+		add("MODE must be one of all, api, bot, got %q", c.Mode)
+	}
+	if !c.LinkPolicy.Valid() {
+		add("LINK_DELETE_POLICY must be one of hide, keep, cascade, got %q", c.LinkPolicy)
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if !c.LogFormat.Valid() {
+		add("LOG_FORMAT must be text or json, got %q", c.LogFormat)
+	}
+	if c.DrainDelay < 0 {
+		add("DRAIN_DELAY must not be negative")
+	}
+	if !c.TraceExporter.Valid() {
+		add("TRACE_EXPORTER must be one of none, stdout, otlp, got %q", c.TraceExporter)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		add("TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+	if c.MaxBodyBytes < 0 {
+		add("MAX_BODY_BYTES must not be negative")
+	}
+	if c.IdempotencyTTL <= 0 {
+		add("IDEMPOTENCY_TTL must be positive")
+	}
+	if c.EventsHeartbeat <= 0 {
+		add("EVENTS_HEARTBEAT must be positive")
+	}
+	if c.WebhookPollInterval <= 0 || c.WebhookTimeout <= 0 || c.WebhookBackoff <= 0 {
+		add("WEBHOOK_POLL_INTERVAL, WEBHOOK_TIMEOUT and WEBHOOK_BACKOFF must be positive")
+	}
+	if c.WebhookMaxAttempts <= 0 {
+		add("WEBHOOK_MAX_ATTEMPTS must be positive")
+	}
+
+	if c.Mode == ServeModeAll || c.Mode == ServeModeAPI {
+		if c.HTTPAddr == "" {
+			add("HTTP_ADDR is required")
+		}
+		if (c.APIUser == "") != (c.APIPassword == "") {
+			add("API_USER and API_PASSWORD must be set together")
+		}
+		if c.HTTPReadTimeout <= 0 || c.HTTPWriteTimeout <= 0 {
+			add("HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT must be positive")
+		}
+	}
+
+	if c.Mode == ServeModeBot && c.BotToken == "" {
+		add("BOT_TOKEN is required in bot mode")
+	}
+	if c.Mode == ServeModeBot || (c.Mode == ServeModeAll && c.BotToken != "") {
+		if c.BotLogin == "" || c.BotPassword == "" {
+			add("BOT_LOGIN and BOT_PASSWORD are required when the bot is enabled")
+		}
+		if c.BotPollTimeout <= 0 {
+			add("BOT_POLL_TIMEOUT must be positive")
+		}
+		if c.BotWorkers <= 0 {
+			add("BOT_WORKERS must be positive")
+		}
 	}
 
-	return Config{
-		BotToken:    os.Getenv("BOT_TOKEN"),
-		DatabaseURL: os.Getenv("DATABASE_URL"),
-		HTTPAddr:    envOrDefault("HTTP_ADDR", ":8080"),
-		APIUser:     os.Getenv("API_USER"),
-		APIPassword: os.Getenv("API_PASSWORD"),
-		BotLogin:    os.Getenv("BOT_LOGIN"),
-		BotPassword: os.Getenv("BOT_PASSWORD"),
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(problems...))
 	}
+	return nil
+}
+
+// configLoader читает значения из окружения и файла конфигурации, накапливая ошибки разбора.
+type configLoader struct {
+	dotenv   map[string]string
+	file     configFile
+	problems []error
+	// known — ключи, которые запрашивала конфигурация, включая варианты *_FILE.
+	known map[string]bool
 }
 
-// envOrDefault возвращает значение переменной окружения или значение по умолчанию.
-func envOrDefault(key, fallback string) string {
+// lookup ищет значение в окружении, затем в .env, затем в файле конфигурации.
+func (l *configLoader) lookup(key string) (string, bool) {
+	if l.known == nil {
+		l.known = make(map[string]bool)
+	}
+	l.known[key] = true
 	if value := os.Getenv(key); value != "" {
+		return value, true
+	}
+	if value := l.dotenv[key]; value != "" {
+		return value, true
+	}
+	value, ok := l.file.values[key]
+	return value, ok && value != ""
+}
+
+// checkUnknownKeys сообщает о ключах файла конфигурации, которые не читает ни один
+// параметр: опечатка в имени иначе молча оставила бы значение по умолчанию.
+func (l *configLoader) checkUnknownKeys() {
+	for _, key := range sortedKeys(l.file.values) {
+		if !l.known[key] {
+			l.problems = append(l.problems, fmt.Errorf("CONFIG_FILE %s:%d: unknown key %q", l.file.path, l.file.lines[key], strings.ToLower(key)))
+		}
+	}
+}
+
+// string возвращает строковое значение или значение по умолчанию.
+func (l *configLoader) string(key, fallback string) string {
+	if value, ok := l.lookup(key); ok {
 		return value
 	}
 	return fallback
 }
+
+// secret возвращает значение секрета из KEY или из файла, указанного в KEY_FILE.
+func (l *configLoader) secret(key string) string {
+	value, hasValue := l.lookup(key)
+	path, hasFile := l.lookup(key + "_FILE")
+	if !hasFile {
+		return value
+	}
+	if hasValue {
+		l.problems = append(l.problems, fmt.Errorf("%s and %s_FILE must not be set together", key, key))
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s_FILE: %w", key, err))
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// duration разбирает длительность вида 10s или 1m30s.
+func (l *configLoader) duration(key string, fallback time.Duration) time.Duration {
+	value, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s must be a duration like 10s, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// int разбирает целое число.
+func (l *configLoader) int(key string, fallback int) int {
+	value, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s must be an integer, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// float разбирает дробное число.
+func (l *configLoader) float(key string, fallback float64) float64 {
+	value, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s must be a number, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// bool разбирает логическое значение.
+func (l *configLoader) bool(key string, fallback bool) bool {
+	value, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s must be a boolean, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// logLevel разбирает уровень логирования: debug, info, warn или error.
+func (l *configLoader) logLevel(key string, fallback slog.Level) slog.Level {
+	value, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		l.problems = append(l.problems, fmt.Errorf("%s must be one of debug, info, warn, error, got %q", key, value))
+		return fallback
+	}
+	return level
+}
+
+// configFile — разобранный файл конфигурации: значения и номера строк ключей для сообщений об ошибках.
+type configFile struct {
+	path   string
+	values map[string]string
+	lines  map[string]int
+}
+
+// configKeyPattern описывает допустимый ключ файла конфигурации.
+var configKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// readConfigFile читает плоский файл конфигурации в формате YAML (key: value)
+// или TOML (key = value). Ключи совпадают с именами переменных окружения
+// и не зависят от регистра: http_addr и HTTP_ADDR равнозначны. Вложенные ключи YAML,
+// разделы и составные ключи TOML, списки и повторы ключей отклоняются с номером строки,
+// чтобы файл не читался иначе, чем задумано.
+func readConfigFile(path string) (configFile, error) {
+	separator := ""
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		separator = ":"
+	case ".toml":
+		separator = "="
+	default:
+		return configFile{}, fmt.Errorf("CONFIG_FILE %q: unsupported format, use .yaml, .yml or .toml", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return configFile{}, fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+	defer file.Close()
+
+	config := configFile{path: path, values: make(map[string]string), lines: make(map[string]int)}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("CONFIG_FILE %s:%d: %s", path, lineNo, fmt.Sprintf(format, args...))
+		}
+		if strings.HasPrefix(line, "[") {
+			return configFile{}, fail("sections are not supported, the file must be flat")
+		}
+		if raw[0] == ' ' || raw[0] == '\t' {
+			return configFile{}, fail("nested keys are not supported, the file must be flat")
+		}
+		key, value, ok := strings.Cut(line, separator)
+		if !ok {
+			return configFile{}, fail("expected key%svalue", separator)
+		}
+		key = strings.TrimSpace(key)
+		if !configKeyPattern.MatchString(key) {
+			return configFile{}, fail("invalid key %q, nested and dotted keys are not supported", key)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" && separator == ":" {
+			return configFile{}, fail("key %q has no value, nested keys are not supported", key)
+		}
+		if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+			return configFile{}, fail("key %q: lists and tables are not supported", key)
+		}
+		value, err := unquoteConfigValue(value, separator == ":")
+		if err != nil {
+			return configFile{}, fail("%v", err)
+		}
+		key = strings.ToUpper(key)
+		if previous, ok := config.lines[key]; ok {
+			return configFile{}, fail("key %q is already set on line %d", strings.ToLower(key), previous)
+		}
+		config.values[key] = value
+		config.lines[key] = lineNo
+	}
+	if err := scanner.Err(); err != nil {
+		return configFile{}, fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+	return config, nil
+}
+
+// unquoteConfigValue снимает кавычки со значения и отбрасывает комментарий в конце строки.
+// Закрывающая кавычка ищется с учетом экранирования, поэтому кавычки в комментарии
+// не считаются частью значения. В одинарных кавычках YAML две кавычки подряд означают одну,
+// в TOML такие строки не поддерживают экранирование.
+func unquoteConfigValue(value string, yaml bool) (string, error) {
+	var quoted, rest string
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingDoubleQuote(value)
+		if end < 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		unquoted, err := strconv.Unquote(value[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", value[:end+1])
+		}
+		quoted, rest = unquoted, value[end+1:]
+	case strings.HasPrefix(value, "'"):
+		var b strings.Builder
+		end := -1
+		for i := 1; i < len(value); i++ {
+			if value[i] != '\'' {
+				b.WriteByte(value[i])
+				continue
+			}
+			if yaml && i+1 < len(value) && value[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			end = i
+			break
+		}
+		if end < 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		quoted, rest = b.String(), value[end+1:]
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return value, nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+	return quoted, nil
+}
+
+// closingDoubleQuote возвращает позицию кавычки, закрывающей строку в двойных
+// кавычках, или -1, если строка не закрыта.
+func closingDoubleQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
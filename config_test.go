@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile создает файл с содержимым во временном каталоге теста и возвращает путь к нему.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFileAccepts(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"yaml plain", "c.yaml", "http_addr: :9090\n", ":9090"},
+		{"yaml comment", "c.yml", "# comment\n---\nhttp_addr: :9090 # port\n", ":9090"},
+		{"yaml double quoted", "c.yaml", `http_addr: "a # b"` + "\n", "a # b"},
+		{"yaml quoted comment with quotes", "c.yaml", `http_addr: "a" # "b"` + "\n", "a"},
+		{"yaml escaped double quote", "c.yaml", `http_addr: "a\"b" # c` + "\n", `a"b`},
+		{"yaml single quoted", "c.yaml", "http_addr: 'a # b' # 'c'\n", "a # b"},
+		{"yaml single quote escape", "c.yaml", "http_addr: 'it''s'\n", "it's"},
+		{"upper case key", "c.yaml", "HTTP_ADDR: :9090\n", ":9090"},
+		{"toml plain", "c.toml", "http_addr = \":9090\"\n", ":9090"},
+		{"toml literal", "c.toml", `http_addr = 'C:\dir' # path` + "\n", `C:\dir`},
+		{"toml empty", "c.toml", "http_addr = \"\"\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), tt.file, tt.content)
+			config, err := readConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.values["HTTP_ADDR"]; got != tt.want {
+				t.Errorf("HTTP_ADDR = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unsupported format", "c.json", "{}", "unsupported format"},
+		{"yaml nested", "c.yaml", "http:\n  addr: :9090\n", ":1: key \"http\" has no value"},
+		{"yaml indented", "c.yaml", "mode: api\n  http_addr: :9090\n", ":2: nested keys"},
+		{"yaml list", "c.yaml", "http_addr: [a, b]\n", "lists and tables"},
+		{"toml section", "c.toml", "[http]\naddr = 1\n", ":1: sections"},
+		{"toml dotted key", "c.toml", "http.addr = 1\n", "invalid key"},
+		{"missing separator", "c.toml", "http_addr\n", "expected key=value"},
+		{"duplicate key", "c.yaml", "http_addr: a\nHTTP_ADDR: b\n", ":2: key \"http_addr\" is already set on line 1"},
+		{"unterminated double", "c.yaml", `http_addr: "a` + "\n", "unterminated"},
+		{"unterminated single", "c.yaml", "http_addr: 'a\n", "unterminated"},
+		{"toml single quote escape", "c.toml", "http_addr = 'it''s'\n", "after quoted value"},
+		{"text after quote", "c.yaml", `http_addr: "a" b` + "\n", "after quoted value"},
+		{"invalid escape", "c.yaml", `http_addr: "a\q"` + "\n", "invalid quoted value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), tt.file, tt.content)
+			_, err := readConfigFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// configDir переходит во временный каталог, чтобы LoadConfig читал .env из него,
+// и очищает переменные окружения, которые проверяет тест.
+func configDir(t *testing.T, keys ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, key := range append(keys, "CONFIG_FILE") {
+		t.Setenv(key, "")
+	}
+	return dir
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := configDir(t, "HTTP_ADDR", "API_USER", "BOT_LOGIN")
+	writeFile(t, dir, ".env", "HTTP_ADDR=:2000\nAPI_USER=dotenv\n")
+	t.Setenv("CONFIG_FILE", writeFile(t, dir, "config.yaml", "http_addr: :3000\napi_user: file\nbot_login: file\n"))
+	t.Setenv("HTTP_ADDR", ":1000")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HTTPAddr != ":1000" || config.APIUser != "dotenv" || config.BotLogin != "file" {
+		t.Errorf("HTTP_ADDR = %q, API_USER = %q, BOT_LOGIN = %q, want env, .env and file values",
+			config.HTTPAddr, config.APIUser, config.BotLogin)
+	}
+}
+
+func TestLoadConfigReadsSecretFiles(t *testing.T) {
+	dir := configDir(t, "DATABASE_URL", "DATABASE_URL_FILE", "API_PASSWORD", "API_PASSWORD_FILE")
+	t.Setenv("DATABASE_URL_FILE", writeFile(t, dir, "database_url", "postgres://db\n"))
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DatabaseURL != "postgres://db" {
+		t.Errorf("DATABASE_URL = %q, want the file content without the newline", config.DatabaseURL)
+	}
+
+	t.Setenv("API_PASSWORD", "inline")
+	t.Setenv("API_PASSWORD_FILE", writeFile(t, dir, "api_password", "secret"))
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "API_PASSWORD and API_PASSWORD_FILE must not be set together") {
+		t.Errorf("error = %v, want a conflict between API_PASSWORD and API_PASSWORD_FILE", err)
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	dir := configDir(t, "HTTP_READ_TIMEOUT", "BOT_WORKERS", "METRICS_TOKEN_FILE")
+	t.Setenv("CONFIG_FILE", writeFile(t, dir, "config.toml", "bot_workers = \"many\"\nhttp_adr = \":80\"\n"))
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+	t.Setenv("METRICS_TOKEN_FILE", filepath.Join(dir, "missing"))
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("invalid configuration is accepted")
+	}
+	for _, want := range []string{
+		`HTTP_READ_TIMEOUT must be a duration like 10s, got "soon"`,
+		`BOT_WORKERS must be an integer, got "many"`,
+		"METRICS_TOKEN_FILE:",
+		`config.toml:2: unknown key "http_adr"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ConsistencyIssue описывает группу связей с одной и той же проблемой.
+type ConsistencyIssue struct {
+	Problem     string `json:"problem"`
+	Description string `json:"description"`
+	LinkIDs     []uint `json:"link_ids"`
+	Repaired    bool   `json:"repaired"`
+}
+
+// ConsistencyReport содержит результат проверки целостности связей.
+type ConsistencyReport struct {
+	Issues []ConsistencyIssue `json:"issues"`
+}
+
+// String формирует текстовый отчет для вывода в консоль.
+func (r ConsistencyReport) String() string {
+	if len(r.Issues) == 0 {
+		return "no problems found"
+	}
+	lines := make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		state := "found"
+		if issue.Repaired {
+			state = "repaired"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s, %d link(s) %s: %s",
+			issue.Problem, issue.Description, len(issue.LinkIDs), state, joinUints(issue.LinkIDs)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// consistencyCheck описывает одну проверку: запрос проблемных связей и способ исправления.
+type consistencyCheck struct {
+	problem     string
+	description string
+	query       string
+	args        []any
+	repair      func(tx *gorm.DB, ids []uint) error
+}
+
+// CheckConsistency проверяет связи между заметками и при repair исправляет найденные проблемы.
+// Проверка не требует актуальной схемы, поэтому ее можно запускать до миграций,
+// которые иначе не смогут создать внешние ключи и уникальный индекс.
+func (s *NotesStore) CheckConsistency(ctx context.Context, repair bool) (ConsistencyReport, error) {
+	report := ConsistencyReport{Issues: []ConsistencyIssue{}}
+	for _, check := range s.consistencyChecks(ctx) {
+		var ids []uint
+		if err := s.db.WithContext(ctx).Raw(check.query, check.args...).Scan(&ids).Error; err != nil {
+			return report, fmt.Errorf("%s: %w", check.problem, err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		issue := ConsistencyIssue{Problem: check.problem, Description: check.description, LinkIDs: ids}
+		if repair {
+			if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				// Как и в writeTx, журналы изменений блокируются до записи. Связи могут
+				// принадлежать разным пользователям, поэтому блокировки берутся по
+				// возрастанию user_id.
+				var users []int64
+				err := tx.Model(&NoteLink{}).Where("id IN ?", ids).Distinct("user_id").Order("user_id").
+					Pluck("user_id", &users).Error
+				if err != nil {
+					return err
+				}
+				for _, user := range users {
+					if err := lockUserChanges(tx, user); err != nil {
+						return err
+					}
+				}
+				return check.repair(tx, ids)
+			}); err != nil {
+				return report, fmt.Errorf("%s: %w", check.problem, err)
+			}
+			issue.Repaired = true
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return report, nil
+}
+
+// consistencyChecks возвращает проверки в порядке, в котором их безопасно исправлять.
+func (s *NotesStore) consistencyChecks(ctx context.Context) []consistencyCheck {
+	deleteLinks := func(tx *gorm.DB, ids []uint) error {
+		return tx.Where("id IN ?", ids).Delete(&NoteLink{}).Error
+	}
+
+	checks := []consistencyCheck{
+		{
+			problem:     "dangling",
+			description: "link points to a note that does not exist",
+			query: `SELECT l.id FROM note_links l
+				LEFT JOIN notes f ON f.id = l.from_id
+				LEFT JOIN notes t ON t.id = l.to_id
+				WHERE f.id IS NULL OR t.id IS NULL ORDER BY l.id`,
+			repair: deleteLinks,
+		},
+		{
+			problem:     "foreign_user",
+			description: "link connects notes of another user",
+			query: `SELECT l.id FROM note_links l
+				JOIN notes f ON f.id = l.from_id
+				JOIN notes t ON t.id = l.to_id
+				WHERE f.user_id <> l.user_id OR t.user_id <> l.user_id ORDER BY l.id`,
+			repair: deleteLinks,
+		},
+		{
+			problem:     "self_link",
+			description: "link points to the same note",
+			query:       `SELECT id FROM note_links WHERE from_id = to_id ORDER BY id`,
+			repair:      deleteLinks,
+		},
+	}
+
+	partition := "user_id, from_id, to_id"
+	if s.db.WithContext(ctx).Migrator().HasColumn(&NoteLink{}, "kind") {
+		// Симметричная связь совпадает со связью того же вида в обратном направлении.
+		partition = `user_id,
+			CASE WHEN kind = 'related' THEN LEAST(from_id, to_id) ELSE from_id END,
+			CASE WHEN kind = 'related' THEN GREATEST(from_id, to_id) ELSE to_id END,
+			kind`
+		checks = append(checks, consistencyCheck{
+			problem:     "unknown_kind",
+			description: "link has an unknown kind, it is reset to reference",
+			query:       `SELECT id FROM note_links WHERE kind IS NULL OR kind NOT IN ? ORDER BY id`,
+			args:        []any{[]LinkKind{LinkKindReference, LinkKindRelated, LinkKindDependsOn}},
+			repair: func(tx *gorm.DB, ids []uint) error {
+				return tx.Model(&NoteLink{}).Where("id IN ?", ids).Update("kind", LinkKindReference).Error
+			},
+		})
+	}
+
+	checks = append(checks, consistencyCheck{
+		problem:     "duplicate",
+		description: "link duplicates an older identical link",
+		query: `SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY ` + partition + ` ORDER BY id) AS rn FROM note_links
+			) d WHERE rn > 1 ORDER BY id`,
+		repair: deleteLinks,
+	})
+
+	if s.linkPolicy == LinkPolicyCascade {
+		checks = append(checks, consistencyCheck{
+			problem:     "deleted_note",
+			description: "link points to a deleted note under the cascade policy",
+			query: `SELECT l.id FROM note_links l
+				JOIN notes f ON f.id = l.from_id
+				JOIN notes t ON t.id = l.to_id
+				WHERE f.status <> ? OR t.status <> ? ORDER BY l.id`,
+			args:   []any{NoteStatusActive, NoteStatusActive},
+			repair: deleteLinks,
+		})
+	}
+	return checks
+}
@@ -2,8 +2,85 @@ package main
 
 import "errors"
 
+// Виды ошибок предметной области. Конкретные ошибки оборачивают один из них,
+// поэтому HTTP API и бот проверяют вид через errors.Is.
+var (
+	// ErrNotFound означает, что заметка, связь или другой объект не существует или удален.
+	ErrNotFound = errors.New("not found")
+	// ErrValidation означает, что входные данные некорректны.
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict означает, что операция противоречит текущему состоянию данных.
+	ErrConflict = errors.New("conflict")
+	// ErrForbidden означает, что у пользователя нет доступа к операции.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// DomainError описывает ошибку предметной области с машиночитаемым кодом.
+type DomainError struct {
+	// Kind — один из ErrNotFound, ErrValidation, ErrConflict, ErrForbidden.
+	Kind error
+	// Code — стабильный код ошибки, например duplicate_link.
+	Code string
+	// Message — описание для разработчика на английском.
+	Message string
+}
+
+// Error возвращает описание ошибки.
+func (e *DomainError) Error() string {
+	return e.Message
+}
+
+// Unwrap позволяет проверять вид ошибки через errors.Is.
+func (e *DomainError) Unwrap() error {
+	return e.Kind
+}
+
+// newNotFound создает ошибку вида ErrNotFound.
+func newNotFound(code, message string) *DomainError {
+	return &DomainError{Kind: ErrNotFound, Code: code, Message: message}
+}
+
+// newValidation создает ошибку вида ErrValidation.
+func newValidation(code, message string) *DomainError {
+	return &DomainError{Kind: ErrValidation, Code: code, Message: message}
+}
+
+// newConflict создает ошибку вида ErrConflict.
+func newConflict(code, message string) *DomainError {
+	return &DomainError{Kind: ErrConflict, Code: code, Message: message}
+}
+
+// newForbidden создает ошибку вида ErrForbidden.
+func newForbidden(code, message string) *DomainError {
+	return &DomainError{Kind: ErrForbidden, Code: code, Message: message}
+}
+
 // errMissingBotToken возвращается при отсутствии токена бота.
 var errMissingBotToken = errors.New("BOT_TOKEN is not set")
 
 // errInvalidUserID используется при неверном идентификаторе пользователя.
-var errInvalidUserID = errors.New("invalid user_id")
+var errInvalidUserID = newValidation("invalid_user_id", "user_id must be a positive integer")
+
+// errDuplicateLink возвращается при попытке создать уже существующую связь.
+var errDuplicateLink = newConflict("duplicate_link", "link already exists")
+
+// errSelfLink возвращается при попытке связать заметку саму с собой.
+var errSelfLink = newValidation("self_link", "from_id and to_id must be different")
+
+// errUnknownLinkKind возвращается для неизвестного вида связи.
+var errUnknownLinkKind = newValidation("invalid_link_kind", "link kind must be one of reference, related, depends_on")
+
+// errNoteNotFound возвращается, если заметка не существует, удалена или принадлежит другому пользователю.
+var errNoteNotFound = newNotFound("note_not_found", "note not found or deleted")
+
+// errLinkNotFound возвращается, если связь не существует или принадлежит другому пользователю.
+var errLinkNotFound = newNotFound("link_not_found", "link not found")
+
+// errVersionMismatch возвращается, если объект изменился после того, как клиент получил его версию.
+var errVersionMismatch = newConflict("version_mismatch", "resource was modified, fetch the current version and retry")
+
+// errNotAuthorized возвращается боту, пока пользователь не выполнил /login.
+var errNotAuthorized = newForbidden("not_authorized", "user is not authorized")
+
+// errSchemaTooNew возвращается, если схема базы данных новее, чем известно сборке.
+var errSchemaTooNew = errors.New("database schema is newer than the binary")
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errPreconditionRequired возвращается, если изменяющий запрос пришел без If-Match.
+var errPreconditionRequired = newValidation("precondition_required", "If-Match header with the current ETag is required")
+
+// versionETag возвращает ETag для объекта с заданной версией.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion возвращает версию из заголовка If-Match. Значение * означает любую
+// версию. Без заголовка запрос отклоняется с errPreconditionRequired, кроме устаревших
+// путей без /v1: их клиенты не знают о версиях, поэтому изменение выполняется без проверки.
+// Слабые и нераспознанные ETag не могут совпасть с текущей версией и дают errVersionMismatch.
+func ifMatchVersion(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if legacy, _ := r.Context().Value(legacyRouteKey).(bool); legacy {
+			return anyVersion, nil
+		}
+		return 0, errPreconditionRequired
+	}
+	if header == "*" {
+		return anyVersion, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err == nil && version > 0 {
+			return version, nil
+		}
+	}
+	return 0, errVersionMismatch
+}
+
+// noneMatch сообщает, совпадает ли etag с одним из значений If-None-Match.
+// Сравнение слабое: префикс W/ не учитывается.
+func noneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag сериализует ответ в JSON с заголовком ETag. Пустой etag вычисляется
+// по содержимому ответа, что подходит для списков. Если клиент прислал тот же ETag
+// в If-None-Match, возвращается 304 без тела.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, data any, etag string) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(data); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if etag == "" {
+		sum := sha256.Sum256(body.Bytes())
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	w.Header().Set("ETag", etag)
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) && noneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
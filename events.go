@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// eventsChannel — канал LISTEN/NOTIFY, в который триггер журнала изменений пишет user_id.
+	eventsChannel = "note_changes"
+	// eventsRetry — через сколько миллисекунд EventSource переподключается после обрыва.
+	eventsRetry = 3000
+	// eventsListenBackoff — пауза перед повторным подключением LISTEN после ошибки.
+	eventsListenBackoff = 5 * time.Second
+)
+
+// EventBroker рассылает подписчикам сигнал о том, что у пользователя появились изменения.
+// Сигнал не несет данных: подписчик сам читает журнал после последнего отправленного
+// номера, поэтому повторные и объединенные сигналы безопасны.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[chan struct{}]struct{}
+	closed      bool
+}
+
+// NewEventBroker создает брокер без подписчиков.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[int64]map[chan struct{}]struct{})}
+}
+
+// Subscribe подписывает на изменения пользователя. Канал закрывается при остановке
+// брокера; возвращаемая функция отменяет подписку.
+func (b *EventBroker) Subscribe(userID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[userID][ch]; !ok {
+			return
+		}
+		delete(b.subscribers[userID], ch)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+	}
+}
+
+// Publish будит подписчиков пользователя; userID, равный нулю, будит всех.
+// Метод не блокируется: если подписчик еще не обработал прошлый сигнал, новый с ним объединяется.
+func (b *EventBroker) Publish(userID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, subscribers := range b.subscribers {
+		if userID != 0 && id != userID {
+			continue
+		}
+		for ch := range subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Close закрывает каналы всех подписчиков, чтобы открытые потоки завершились
+// и не задерживали остановку HTTP-сервера.
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	b.subscribers = nil
+}
+
+// Subscribers возвращает число открытых подписок.
+func (b *EventBroker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	for _, subscribers := range b.subscribers {
+		count += len(subscribers)
+	}
+	return count
+}
+
+// eventListenerService слушает канал note_changes в PostgreSQL и передает уведомления
+// в брокер. Нужен, когда API и бот работают в разных процессах или репликах.
+type eventListenerService struct {
+	broker      *EventBroker
+	databaseURL string
+	ready       atomic.Bool
+}
+
+// Name возвращает имя компонента.
+func (s *eventListenerService) Name() string {
+	return "events"
+}
+
+// Ready сообщает, подключен ли LISTEN.
+func (s *eventListenerService) Ready() bool {
+	return s.ready.Load()
+}
+
+// Run держит соединение LISTEN до отмены ctx и переподключается после ошибок.
+func (s *eventListenerService) Run(ctx context.Context) error {
+	for {
+		err := s.listen(ctx)
+		s.ready.Store(false)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn("event listener disconnected", "error", err, "retry_in", eventsListenBackoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(eventsListenBackoff):
+		}
+	}
+}
+
+// listen подключается к базе и пересылает уведомления, пока соединение живо.
+func (s *eventListenerService) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, s.databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return err
+	}
+	s.ready.Store(true)
+	slog.Info("listening for changes", "channel", eventsChannel)
+	// Пока соединения не было, уведомления могли потеряться: подписчики перечитают журнал.
+	s.broker.Publish(0)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		userID, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			slog.Warn("unexpected change notification", "payload", notification.Payload)
+			continue
+		}
+		s.broker.Publish(userID)
+	}
+}
+
+// EnableEvents публикует GET /v1/events с потоком изменений из брокера.
+// heartbeat задает период комментариев, которые держат соединение открытым через прокси.
+func (a *API) EnableEvents(broker *EventBroker, heartbeat time.Duration) {
+	a.events = broker
+	a.eventsHeartbeat = heartbeat
+}
+
+// handleEvents отдает изменения заметок и связей пользователя в формате Server-Sent Events.
+// Идентификатор события — номер в журнале изменений, поэтому после переподключения
+// с Last-Event-ID клиент получает пропущенные события. Без него поток начинается
+// с текущего момента.
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var since int64
+	if lastID != "" {
+		since, err = strconv.ParseInt(lastID, 10, 64)
+		if err != nil || since < 0 {
+			writeError(w, r, invalidParameter("Last-Event-ID"))
+			return
+		}
+	}
+	if a.events == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, "events_disabled", "event stream is not available")
+		return
+	}
+
+	// Подписка оформляется до чтения журнала, чтобы не пропустить изменения между ними.
+	notify, unsubscribe := a.events.Subscribe(userID)
+	defer unsubscribe()
+	if lastID == "" {
+		since, err = a.store.LatestChangeSeq(r.Context(), userID)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("latest change: %w", err))
+			return
+		}
+	}
+
+	controller := http.NewResponseController(w)
+	// Поток живет дольше HTTP_WRITE_TIMEOUT, поэтому срок записи снимается.
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "event stream write deadline not cleared", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+
+	send := func() error {
+		for {
+			events, hasMore, err := a.store.ChangeEvents(r.Context(), userID, since, defaultSyncLimit)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				if err := writeEvent(w, event); err != nil {
+					return err
+				}
+				since = event.Seq
+			}
+			if !hasMore {
+				return controller.Flush()
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(a.eventsHeartbeat)
+	defer heartbeat.Stop()
+	err = send()
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-notify:
+			if !ok {
+				return
+			}
+			err = send()
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err == nil {
+				err = controller.Flush()
+			}
+		}
+	}
+	if r.Context().Err() == nil {
+		slog.WarnContext(r.Context(), "event stream stopped", "error", err)
+	}
+}
+
+// writeEvent записывает одно событие: note.changed и link.changed содержат объект
+// целиком, note.deleted и link.deleted — только его идентификатор.
+func writeEvent(w http.ResponseWriter, event ChangeEvent) error {
+	name := event.Entity + ".changed"
+	var data any = map[string]uint{"id": event.ID}
+	switch {
+	case event.Deleted:
+		name = event.Entity + ".deleted"
+	case event.Note != nil:
+		data = event.Note
+	case event.Link != nil:
+		data = event.Link
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, name, payload)
+	return err
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// exportFormatVersion увеличивается при несовместимых изменениях формата выгрузки.
+const exportFormatVersion = 1
+
+// UserExport описывает полную выгрузку заметок и связей пользователя.
+// Tags вычисляются по тексту заметок и при загрузке не используются.
+type UserExport struct {
+	Version    int         `json:"version"`
+	UserID     int64       `json:"user_id"`
+	ExportedAt time.Time   `json:"exported_at"`
+	Notes      []Note      `json:"notes"`
+	Links      []NoteLink  `json:"links"`
+	Tags       []ExportTag `json:"tags,omitempty"`
+}
+
+// ExportTag описывает тег и заметки, в тексте которых он встречается.
+type ExportTag struct {
+	Name    string `json:"name"`
+	NoteIDs []uint `json:"note_ids"`
+}
+
+// ImportSummary описывает результат загрузки данных.
+// Format, Preview и Warnings заполняет Import при загрузке файла.
+type ImportSummary struct {
+	Format       ImportFormat `json:"format,omitempty"`
+	DryRun       bool         `json:"dry_run"`
+	Notes        int          `json:"notes"`
+	Links        int          `json:"links"`
+	SkippedLinks int          `json:"skipped_links"`
+	Preview      []string     `json:"preview,omitempty"`
+	Warnings     []string     `json:"warnings,omitempty"`
+}
+
+// ExportUser выгружает все заметки пользователя, включая удаленные, и все его связи.
+func (s *NotesStore) ExportUser(ctx context.Context, userID int64) (UserExport, error) {
+	dump := UserExport{Version: exportFormatVersion, UserID: userID, ExportedAt: time.Now().UTC()}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("id asc").Find(&dump.Notes).Error; err != nil {
+		return UserExport{}, err
+	}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("id asc").Find(&dump.Links).Error; err != nil {
+		return UserExport{}, err
+	}
+	dump.Tags = exportTags(dump.Notes)
+	return dump, nil
+}
+
+// ImportUser загружает выгрузку в заметки пользователя userID в одной транзакции.
+// Заметки получают новые идентификаторы, связи переносятся с учетом новых номеров.
+// Связи на заметки, которых нет в выгрузке, пропускаются. При dryRun транзакция
+// откатывается, а отчет описывает, что было бы загружено.
+func (s *NotesStore) ImportUser(ctx context.Context, userID int64, dump UserExport, dryRun bool) (ImportSummary, error) {
+	summary := ImportSummary{DryRun: dryRun}
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		ids := make(map[uint]uint, len(dump.Notes))
+		for _, source := range dump.Notes {
+			note := Note{
+				UserID:    userID,
+				Text:      source.Text,
+				Status:    source.Status,
+				Version:   1,
+				CreatedAt: source.CreatedAt,
+				UpdatedAt: source.UpdatedAt,
+			}
+			if note.Status != NoteStatusDeleted {
+				note.Status = NoteStatusActive
+			}
+			if err := tx.Create(&note).Error; err != nil {
+				return err
+			}
+			ids[source.ID] = note.ID
+			summary.Notes++
+		}
+
+		for _, source := range dump.Links {
+			fromID, okFrom := ids[source.FromID]
+			toID, okTo := ids[source.ToID]
+			kind := source.Kind
+			if kind == "" {
+				kind = LinkKindReference
+			}
+			if !okFrom || !okTo || fromID == toID || !kind.Valid() {
+				summary.SkippedLinks++
+				continue
+			}
+			link := NoteLink{UserID: userID, FromID: fromID, ToID: toID, Kind: kind, Version: 1}
+			result := tx.Where(NoteLink{UserID: userID, FromID: fromID, ToID: toID, Kind: kind}).FirstOrCreate(&link)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				summary.SkippedLinks++
+				continue
+			}
+			summary.Links++
+		}
+		if dryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errImportDryRun) {
+		return summary, nil
+	}
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	s.changed(userID)
+	return summary, nil
+}
+
+// ExportFormat — формат файла выгрузки.
+type ExportFormat string
+
+const (
+	// ExportJSON — полная выгрузка UserExport, которую принимает import.
+	ExportJSON ExportFormat = "json"
+	// ExportMarkdown — zip с файлом Markdown на каждую активную заметку и связями в виде [[wikilinks]].
+	ExportMarkdown ExportFormat = "markdown"
+	// ExportCSV — таблица заметок со статусом, тегами и исходящими связями.
+	ExportCSV ExportFormat = "csv"
+)
+
+// Valid проверяет, что формат известен.
+func (f ExportFormat) Valid() bool {
+	switch f {
+	case ExportJSON, ExportMarkdown, ExportCSV:
+		return true
+	}
+	return false
+}
+
+// ContentType возвращает MIME-тип файла выгрузки.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportMarkdown:
+		return "application/zip"
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// FileName возвращает имя файла выгрузки пользователя, например notes-123-20240102.zip.
+func (f ExportFormat) FileName(userID int64, at time.Time) string {
+	extension := string(f)
+	if f == ExportMarkdown {
+		extension = "zip"
+	}
+	return fmt.Sprintf("notes-%d-%s.%s", userID, at.UTC().Format("20060102"), extension)
+}
+
+// WriteExport записывает выгрузку в заданном формате.
+func WriteExport(w io.Writer, dump UserExport, format ExportFormat) error {
+	switch format {
+	case ExportMarkdown:
+		return writeMarkdownExport(w, dump)
+	case ExportCSV:
+		return writeCSVExport(w, dump)
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(dump)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// hashtagPattern находит теги #tag, стоящие отдельным словом, так же как фильтр tag в NoteFilter.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])#([\p{L}\p{N}_-]{1,64})`)
+
+// noteTags возвращает теги из текста заметки в нижнем регистре без повторов.
+func noteTags(text string) []string {
+	var tags []string
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// exportTags собирает теги заметок в алфавитном порядке.
+func exportTags(notes []Note) []ExportTag {
+	byName := make(map[string][]uint)
+	for _, note := range notes {
+		for _, tag := range noteTags(note.Text) {
+			byName[tag] = append(byName[tag], note.ID)
+		}
+	}
+	tags := make([]ExportTag, 0, len(byName))
+	for _, name := range sortedKeys(byName) {
+		tags = append(tags, ExportTag{Name: name, NoteIDs: byName[name]})
+	}
+	return tags
+}
+
+// writeCSVExport записывает по строке на заметку, включая удаленные. Связи указываются
+// в колонке links как kind:to_id через пробел, теги — в колонке tags через пробел.
+func writeCSVExport(w io.Writer, dump UserExport) error {
+	outgoing := make(map[uint][]string)
+	for _, link := range dump.Links {
+		outgoing[link.FromID] = append(outgoing[link.FromID], fmt.Sprintf("%s:%d", link.Kind, link.ToID))
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "status", "version", "created_at", "updated_at", "tags", "links", "text"}); err != nil {
+		return err
+	}
+	for _, note := range dump.Notes {
+		err := writer.Write([]string{
+			strconv.FormatUint(uint64(note.ID), 10),
+			string(note.Status),
+			strconv.FormatInt(note.Version, 10),
+			note.CreatedAt.UTC().Format(time.RFC3339),
+			note.UpdatedAt.UTC().Format(time.RFC3339),
+			strings.Join(noteTags(note.Text), " "),
+			strings.Join(outgoing[note.ID], " "),
+			note.Text,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// markdownNameLimit ограничивает длину имени файла заметки в символах.
+const markdownNameLimit = 60
+
+// markdownUnsafe содержит символы, недопустимые в именах файлов и [[wikilinks]].
+const markdownUnsafe = `/\:*?"<>|#^[]`
+
+// markdownNames подбирает заметкам уникальные имена файлов по первой строке текста.
+// Имя без расширения служит целью [[wikilink]]. При совпадении добавляется номер заметки.
+func markdownNames(notes []Note) map[uint]string {
+	names := make(map[uint]string, len(notes))
+	taken := make(map[string]bool, len(notes))
+	for _, note := range notes {
+		title, _, _ := strings.Cut(strings.TrimSpace(note.Text), "\n")
+		title = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(markdownUnsafe, r) {
+				return ' '
+			}
+			return r
+		}, title)
+		title = strings.Trim(strings.Join(strings.Fields(title), " "), ". ")
+		if runes := []rune(title); len(runes) > markdownNameLimit {
+			title = strings.TrimSpace(string(runes[:markdownNameLimit]))
+		}
+		if title == "" {
+			title = fmt.Sprintf("note-%d", note.ID)
+		}
+		if taken[strings.ToLower(title)] {
+			title = fmt.Sprintf("%s (%d)", title, note.ID)
+		}
+		taken[strings.ToLower(title)] = true
+		names[note.ID] = title
+	}
+	return names
+}
+
+// writeMarkdownExport записывает zip с файлом на каждую активную заметку. В начале файла
+// идет блок front matter с номером, датами и тегами, затем текст и раздел «Связи»
+// со строками вида «- kind: [[Имя заметки]]». Симметричная связь указывается у исходной заметки.
+func writeMarkdownExport(w io.Writer, dump UserExport) error {
+	var notes []Note
+	for _, note := range dump.Notes {
+		if note.Status == NoteStatusActive {
+			notes = append(notes, note)
+		}
+	}
+	names := markdownNames(notes)
+	outgoing := make(map[uint][]NoteLink)
+	for _, link := range dump.Links {
+		_, fromOK := names[link.FromID]
+		_, toOK := names[link.ToID]
+		if fromOK && toOK {
+			outgoing[link.FromID] = append(outgoing[link.FromID], link)
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	for _, note := range notes {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: names[note.ID] + ".md", Method: zip.Deflate, Modified: note.UpdatedAt})
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "---\nid: %d\ncreated: %s\nupdated: %s\n", note.ID,
+			note.CreatedAt.UTC().Format(time.RFC3339), note.UpdatedAt.UTC().Format(time.RFC3339))
+		if tags := noteTags(note.Text); len(tags) > 0 {
+			fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+		}
+		fmt.Fprintf(&b, "---\n\n%s\n", strings.TrimSpace(note.Text))
+		if links := outgoing[note.ID]; len(links) > 0 {
+			b.WriteString("\n## Связи\n\n")
+			for _, link := range links {
+				fmt.Fprintf(&b, "- %s: [[%s]]\n", link.Kind, names[link.ToID])
+			}
+		}
+		if _, err := io.WriteString(file, b.String()); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
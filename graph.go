@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// maxGraphDepth ограничивает глубину обхода графа связей.
+	maxGraphDepth = 6
+	// maxGraphNodes ограничивает число заметок, которые посещает один обход графа.
+	maxGraphNodes = 10000
+	// graphStatementTimeout ограничивает время каждого SQL-запроса по графу.
+	graphStatementTimeout = 5 * time.Second
+)
+
+// errGraphTooLarge возвращается, если обход графа посетил больше maxGraphNodes заметок.
+var errGraphTooLarge = newValidation("graph_too_large", fmt.Sprintf("graph traversal visits more than %d notes, reduce depth", maxGraphNodes))
+
+// GraphNode описывает заметку, найденную при обходе графа.
+type GraphNode struct {
+	ID    uint   `json:"id"`
+	Depth int    `json:"depth"`
+	Text  string `json:"text"`
+}
+
+// GraphNeighborhood описывает окрестность заметки: найденные заметки и связи между ними.
+type GraphNeighborhood struct {
+	Nodes []GraphNode `json:"nodes"`
+	Links []NoteLink  `json:"links"`
+}
+
+// graphEdgesCTE возвращает CTE edges(a, b) со связями между активными заметками пользователя.
+// Симметричные связи всегда проходимы в обе стороны, остальные — только при undirected.
+const graphEdgesCTE = `edges(a, b) AS (
+	SELECT l.from_id, l.to_id FROM note_links l
+	JOIN notes f ON f.id = l.from_id AND f.user_id = l.user_id AND f.status = @active
+	JOIN notes t ON t.id = l.to_id AND t.user_id = l.user_id AND t.status = @active
+	WHERE l.user_id = @user
+	UNION
+	SELECT l.to_id, l.from_id FROM note_links l
+	JOIN notes f ON f.id = l.from_id AND f.user_id = l.user_id AND f.status = @active
+	JOIN notes t ON t.id = l.to_id AND t.user_id = l.user_id AND t.status = @active
+	WHERE l.user_id = @user AND (@undirected OR l.kind IN @symmetric)
+)`
+
+// graphArgs собирает общие именованные параметры для запросов по графу.
+func graphArgs(userID int64, undirected bool) map[string]any {
+	return map[string]any{
+		"user":       userID,
+		"active":     NoteStatusActive,
+		"undirected": undirected,
+		"symmetric":  symmetricLinkKinds(),
+	}
+}
+
+// graphEdge — связь графа от заметки A к заметке B.
+type graphEdge struct {
+	A uint
+	B uint
+}
+
+// graphTx выполняет запросы по графу в транзакции только для чтения, где каждый
+// запрос ограничен graphStatementTimeout.
+func (s *NotesStore) graphTx(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		timeout := strconv.FormatInt(graphStatementTimeout.Milliseconds(), 10)
+		if err := tx.Exec("SELECT set_config('statement_timeout', ?, true)", timeout).Error; err != nil {
+			return err
+		}
+		return fn(tx)
+	}, &sql.TxOptions{ReadOnly: true})
+}
+
+// graphNeighbors возвращает ребра графа, выходящие из заметок frontier.
+func graphNeighbors(tx *gorm.DB, args map[string]any, frontier []uint) ([]graphEdge, error) {
+	args["frontier"] = frontier
+	var edges []graphEdge
+	err := tx.Raw(`WITH `+graphEdgesCTE+`
+	SELECT a, b FROM edges WHERE a IN @frontier ORDER BY a, b`, args).Scan(&edges).Error
+	return edges, err
+}
+
+// graphBFS обходит граф в ширину от start по уровням, не глубже depth. Каждая заметка
+// посещается один раз с наименьшей глубиной, поэтому число запросов не больше depth,
+// а объем работы ограничен maxGraphNodes. Обход останавливается, как только найдена
+// target, если она задана. Возвращает глубину каждой найденной заметки и заметку,
+// из которой в нее пришли.
+func graphBFS(tx *gorm.DB, args map[string]any, start uint, depth int, target uint) (map[uint]int, map[uint]uint, error) {
+	depths := map[uint]int{start: 0}
+	parents := make(map[uint]uint)
+	frontier := []uint{start}
+	for level := 1; level <= depth && len(frontier) > 0; level++ {
+		if _, found := depths[target]; found && target != 0 {
+			break
+		}
+		edges, err := graphNeighbors(tx, args, frontier)
+		if err != nil {
+			return nil, nil, err
+		}
+		var next []uint
+		for _, edge := range edges {
+			if _, seen := depths[edge.B]; seen {
+				continue
+			}
+			depths[edge.B] = level
+			parents[edge.B] = edge.A
+			next = append(next, edge.B)
+		}
+		if len(depths) > maxGraphNodes {
+			return nil, nil, errGraphTooLarge
+		}
+		frontier = next
+	}
+	return depths, parents, nil
+}
+
+// Neighborhood возвращает заметки, достижимые из noteID не более чем за depth связей
+// в любом направлении, и связи между ними.
+func (s *NotesStore) Neighborhood(ctx context.Context, userID int64, noteID int, depth int) (GraphNeighborhood, bool, error) {
+	note, found, err := s.GetNote(ctx, userID, noteID)
+	if err != nil || !found {
+		return GraphNeighborhood{}, found, err
+	}
+	depth = clampDepth(depth)
+
+	var depths map[uint]int
+	err = s.graphTx(ctx, func(tx *gorm.DB) error {
+		var err error
+		depths, _, err = graphBFS(tx, graphArgs(userID, true), note.ID, depth, 0)
+		return err
+	})
+	if err != nil {
+		return GraphNeighborhood{}, false, err
+	}
+
+	ids := make([]uint, 0, len(depths))
+	for id := range depths {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if depths[ids[i]] != depths[ids[j]] {
+			return depths[ids[i]] < depths[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	notes, err := s.ListNotesByIDs(ctx, userID, ids)
+	if err != nil {
+		return GraphNeighborhood{}, false, err
+	}
+	texts := make(map[uint]string, len(notes))
+	for _, n := range notes {
+		texts[n.ID] = n.Text
+	}
+	texts[note.ID] = note.Text
+
+	result := GraphNeighborhood{Nodes: make([]GraphNode, 0, len(ids)), Links: []NoteLink{}}
+	for _, id := range ids {
+		result.Nodes = append(result.Nodes, GraphNode{ID: id, Depth: depths[id], Text: texts[id]})
+	}
+
+	err = s.db.WithContext(ctx).
+		Where("user_id = ? AND from_id IN ? AND to_id IN ?", userID, ids, ids).
+		Order("id asc").
+		Find(&result.Links).Error
+	if err != nil {
+		return GraphNeighborhood{}, false, err
+	}
+	return result, true, nil
+}
+
+// ShortestPath ищет кратчайшую цепочку заметок от fromID до toID не длиннее maxGraphDepth связей.
+// Направленные связи проходятся только по направлению, если undirected не задан.
+func (s *NotesStore) ShortestPath(ctx context.Context, userID int64, fromID, toID int, undirected bool) ([]Note, bool, error) {
+	start, target := uint(fromID), uint(toID)
+	var parents map[uint]uint
+	var found bool
+	err := s.graphTx(ctx, func(tx *gorm.DB) error {
+		depths, p, err := graphBFS(tx, graphArgs(userID, undirected), start, maxGraphDepth, target)
+		parents = p
+		_, found = depths[target]
+		return err
+	})
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	ids := []uint{target}
+	for id := target; id != start; {
+		id = parents[id]
+		ids = append(ids, id)
+	}
+	slices.Reverse(ids)
+	notes, err := s.ListNotesByIDs(ctx, userID, ids)
+	if err != nil {
+		return nil, false, err
+	}
+	byID := make(map[uint]Note, len(notes))
+	for _, n := range notes {
+		byID[n.ID] = n
+	}
+	ordered := make([]Note, 0, len(ids))
+	for _, id := range ids {
+		n, ok := byID[id]
+		if !ok {
+			return nil, false, nil
+		}
+		ordered = append(ordered, n)
+	}
+	return ordered, true, nil
+}
+
+// Components разбивает активные заметки пользователя на компоненты связности,
+// не учитывая направление связей. Заметки без связей образуют отдельные компоненты.
+// Заметки и связи читаются двумя запросами, а компоненты собираются в памяти
+// за линейное время.
+func (s *NotesStore) Components(ctx context.Context, userID int64) ([][]uint, error) {
+	var (
+		ids   []uint
+		edges []graphEdge
+	)
+	err := s.graphTx(ctx, func(tx *gorm.DB) error {
+		err := tx.Model(&Note{}).Where("user_id = ? AND status = ?", userID, NoteStatusActive).
+			Order("id asc").Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		return tx.Raw(`WITH `+graphEdgesCTE+` SELECT a, b FROM edges WHERE a < b`, graphArgs(userID, true)).Scan(&edges).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Система непересекающихся множеств: корень каждого множества — наименьшая заметка.
+	parent := make(map[uint]uint, len(ids))
+	for _, id := range ids {
+		parent[id] = id
+	}
+	find := func(id uint) uint {
+		for parent[id] != id {
+			parent[id] = parent[parent[id]]
+			id = parent[id]
+		}
+		return id
+	}
+	for _, edge := range edges {
+		a, b := find(edge.A), find(edge.B)
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a
+	}
+
+	components := make([][]uint, 0)
+	index := make(map[uint]int)
+	for _, id := range ids {
+		root := find(id)
+		i, ok := index[root]
+		if !ok {
+			i = len(components)
+			index[root] = i
+			components = append(components, nil)
+		}
+		components[i] = append(components[i], id)
+	}
+	return components, nil
+}
+
+// DependencyCycles находит циклы среди связей вида depends_on. Для каждой группы
+// взаимно зависимых заметок (компоненты сильной связности) возвращается один
+// кратчайший цикл, начинающийся с наименьшего идентификатора группы. Поиск идет
+// в памяти за время, линейное от числа связей.
+func (s *NotesStore) DependencyCycles(ctx context.Context, userID int64) ([][]uint, error) {
+	args := graphArgs(userID, false)
+	args["kind"] = LinkKindDependsOn
+
+	var edges []graphEdge
+	err := s.graphTx(ctx, func(tx *gorm.DB) error {
+		return tx.Raw(`SELECT l.from_id AS a, l.to_id AS b FROM note_links l
+		JOIN notes f ON f.id = l.from_id AND f.user_id = l.user_id AND f.status = @active
+		JOIN notes t ON t.id = l.to_id AND t.user_id = l.user_id AND t.status = @active
+		WHERE l.user_id = @user AND l.kind = @kind
+		ORDER BY l.from_id, l.to_id`, args).Scan(&edges).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	next := make(map[uint][]uint)
+	for _, edge := range edges {
+		next[edge.A] = append(next[edge.A], edge.B)
+	}
+	cycles := make([][]uint, 0)
+	for _, group := range stronglyConnected(next) {
+		if cycle := shortestCycle(next, group); cycle != nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles, nil
+}
+
+// stronglyConnected возвращает компоненты сильной связности графа по алгоритму Тарьяна.
+func stronglyConnected(next map[uint][]uint) [][]uint {
+	var (
+		index   = make(map[uint]int)
+		low     = make(map[uint]int)
+		onStack = make(map[uint]bool)
+		stack   []uint
+		groups  [][]uint
+	)
+	var visit func(uint)
+	visit = func(v uint) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range next[v] {
+			if _, seen := index[w]; !seen {
+				visit(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var group []uint
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			group = append(group, w)
+			if w == v {
+				break
+			}
+		}
+		groups = append(groups, group)
+	}
+	for _, v := range slices.Sorted(maps.Keys(next)) {
+		if _, seen := index[v]; !seen {
+			visit(v)
+		}
+	}
+	return groups
+}
+
+// shortestCycle ищет кратчайший цикл через наименьшую заметку группы, не выходя за ее
+// пределы. Для группы из одной заметки цикл есть только при связи заметки с самой собой.
+func shortestCycle(next map[uint][]uint, group []uint) []uint {
+	start := slices.Min(group)
+	inGroup := make(map[uint]bool, len(group))
+	for _, id := range group {
+		inGroup[id] = true
+	}
+	parents := map[uint]uint{start: start}
+	queue := []uint{start}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range next[v] {
+			if w == start {
+				cycle := []uint{v}
+				for v != start {
+					v = parents[v]
+					cycle = append(cycle, v)
+				}
+				slices.Reverse(cycle)
+				return cycle
+			}
+			if _, seen := parents[w]; seen || !inGroup[w] {
+				continue
+			}
+			parents[w] = v
+			queue = append(queue, w)
+		}
+	}
+	return nil
+}
+
+// clampDepth приводит глубину обхода к допустимому диапазону.
+func clampDepth(depth int) int {
+	if depth < 1 {
+		return 1
+	}
+	if depth > maxGraphDepth {
+		return maxGraphDepth
+	}
+	return depth
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Размеры элементов при отрисовке графа.
+const (
+	graphNodeWidth  = 180
+	graphNodeHeight = 36
+	graphColumnGap  = 80
+	graphRowGap     = 24
+	graphMargin     = 20
+	graphLabelLimit = 22
+	graphFontSize   = 12
+	// graphMaxWidth — ширина, после которой компоненты переносятся на следующую полосу.
+	graphMaxWidth = 4000
+	// graphMaxHeight ограничивает высоту изображения и вместе с graphMaxWidth — память на отрисовку.
+	graphMaxHeight = 6000
+)
+
+// errGraphImageTooLarge возвращается, если граф не помещается в graphMaxWidth×graphMaxHeight.
+var errGraphImageTooLarge = newValidation("graph_image_too_large", "graph is too large to render as an image, use svg, dot or mermaid")
+
+// UserGraph описывает все активные заметки пользователя и связи между ними.
+type UserGraph struct {
+	Notes []Note     `json:"notes"`
+	Links []NoteLink `json:"links"`
+}
+
+// UserGraph возвращает граф активных заметок пользователя.
+// Связи, у которых одна из заметок удалена, не попадают в граф.
+func (s *NotesStore) UserGraph(ctx context.Context, userID int64) (UserGraph, error) {
+	notes, err := s.ListNotes(ctx, userID)
+	if err != nil {
+		return UserGraph{}, err
+	}
+	links, err := s.ListLinks(ctx, userID)
+	if err != nil {
+		return UserGraph{}, err
+	}
+
+	active := make(map[uint]bool, len(notes))
+	for _, note := range notes {
+		active[note.ID] = true
+	}
+	graph := UserGraph{Notes: notes, Links: make([]NoteLink, 0, len(links))}
+	if graph.Notes == nil {
+		graph.Notes = []Note{}
+	}
+	for _, link := range links {
+		if active[link.FromID] && active[link.ToID] {
+			graph.Links = append(graph.Links, link)
+		}
+	}
+	return graph, nil
+}
+
+// renderGraphDOT формирует описание графа на языке Graphviz DOT.
+func renderGraphDOT(g UserGraph) string {
+	var b strings.Builder
+	b.WriteString("digraph notes {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for _, note := range g.Notes {
+		fmt.Fprintf(&b, "\tn%d [label=%s];\n", note.ID, dotQuote(graphLabel(note)))
+	}
+	for _, link := range g.Links {
+		attrs := []string{"label=" + dotQuote(string(link.Kind))}
+		if link.Kind.Symmetric() {
+			attrs = append(attrs, "dir=none")
+		}
+		fmt.Fprintf(&b, "\tn%d -> n%d [%s];\n", link.FromID, link.ToID, strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// noteTitle возвращает заголовок заметки — первую непустую строку, не длиннее limit символов.
+func noteTitle(text string, limit int) string {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			return previewText(line, limit)
+		}
+	}
+	return ""
+}
+
+// graphLabel возвращает подпись узла графа: номер и заголовок заметки.
+func graphLabel(note Note) string {
+	return fmt.Sprintf("%d. %s", note.ID, noteTitle(note.Text, graphLabelLimit))
+}
+
+// dotQuote экранирует строку для использования в DOT.
+func dotQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// renderGraphMermaid формирует описание графа в синтаксисе Mermaid.
+func renderGraphMermaid(g UserGraph) string {
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for _, note := range g.Notes {
+		label := strings.ReplaceAll(graphLabel(note), `"`, "#quot;")
+		fmt.Fprintf(&b, "\tn%d[\"%s\"]\n", note.ID, label)
+	}
+	for _, link := range g.Links {
+		switch link.Kind {
+		case LinkKindRelated:
+			fmt.Fprintf(&b, "\tn%d --- n%d\n", link.FromID, link.ToID)
+		case LinkKindDependsOn:
+			fmt.Fprintf(&b, "\tn%d -->|depends_on| n%d\n", link.FromID, link.ToID)
+		default:
+			fmt.Fprintf(&b, "\tn%d --> n%d\n", link.FromID, link.ToID)
+		}
+	}
+	return b.String()
+}
+
+// graphPoint описывает левый верхний угол узла на схеме.
+type graphPoint struct {
+	X, Y int
+}
+
+// graphLayout хранит расположение узлов и размеры схемы.
+type graphLayout struct {
+	Positions map[uint]graphPoint
+	Width     int
+	Height    int
+}
+
+// layoutGraph располагает заметки по слоям: каждая компонента связности
+// раскладывается обходом в ширину от корня. Компоненты ставятся слева направо
+// полосами не шире graphMaxWidth, поэтому множество отдельных заметок
+// складывается в сетку, а не в один столбец.
+func layoutGraph(g UserGraph) graphLayout {
+	adjacent := make(map[uint][]uint)
+	incoming := make(map[uint]int)
+	for _, link := range g.Links {
+		adjacent[link.FromID] = append(adjacent[link.FromID], link.ToID)
+		adjacent[link.ToID] = append(adjacent[link.ToID], link.FromID)
+		if !link.Kind.Symmetric() {
+			incoming[link.ToID]++
+		}
+	}
+	ids := make([]uint, 0, len(g.Notes))
+	for _, note := range g.Notes {
+		ids = append(ids, note.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for id := range adjacent {
+		sort.Slice(adjacent[id], func(i, j int) bool { return adjacent[id][i] < adjacent[id][j] })
+	}
+
+	layout := graphLayout{Positions: make(map[uint]graphPoint, len(ids))}
+	visited := make(map[uint]bool, len(ids))
+	left, top, shelfHeight := graphMargin, graphMargin, 0
+	for _, start := range ids {
+		if visited[start] {
+			continue
+		}
+		component := bfsOrder(start, adjacent, nil)
+		root := component[0]
+		for _, id := range component {
+			if incoming[id] == 0 && (incoming[root] != 0 || id < root) {
+				root = id
+			}
+		}
+
+		layers := make(map[uint]int, len(component))
+		bfsOrder(root, adjacent, layers)
+		rows := make(map[int]int)
+		columns, height := 0, 0
+		for _, id := range component {
+			visited[id] = true
+			rows[layers[id]]++
+			columns = max(columns, layers[id]+1)
+			height = max(height, rows[layers[id]])
+		}
+		width := columns*(graphNodeWidth+graphColumnGap) - graphColumnGap
+		if left > graphMargin && left+width+graphMargin > graphMaxWidth {
+			left, top, shelfHeight = graphMargin, top+shelfHeight, 0
+		}
+
+		clear(rows)
+		for _, id := range component {
+			layer := layers[id]
+			layout.Positions[id] = graphPoint{
+				X: left + layer*(graphNodeWidth+graphColumnGap),
+				Y: top + rows[layer]*(graphNodeHeight+graphRowGap),
+			}
+			rows[layer]++
+		}
+		left += width + graphColumnGap
+		layout.Width = max(layout.Width, left-graphColumnGap+graphMargin)
+		shelfHeight = max(shelfHeight, height*(graphNodeHeight+graphRowGap))
+	}
+
+	layout.Height = top + shelfHeight - graphRowGap + graphMargin
+	if len(ids) == 0 {
+		layout.Width = 2*graphMargin + graphNodeWidth
+		layout.Height = 2*graphMargin + graphNodeHeight
+	}
+	return layout
+}
+
+// bfsOrder обходит граф в ширину и возвращает узлы в порядке обхода.
+// Если передан layers, в него записывается расстояние каждого узла от start.
+func bfsOrder(start uint, adjacent map[uint][]uint, layers map[uint]int) []uint {
+	seen := map[uint]bool{start: true}
+	depth := map[uint]int{start: 0}
+	order := []uint{start}
+	for i := 0; i < len(order); i++ {
+		current := order[i]
+		for _, next := range adjacent[current] {
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			depth[next] = depth[current] + 1
+			order = append(order, next)
+		}
+	}
+	if layers != nil {
+		for id, d := range depth {
+			layers[id] = d
+		}
+	}
+	return order
+}
+
+// graphEdgeEnds возвращает концы отрезка между границами двух узлов.
+func graphEdgeEnds(from, to graphPoint) (x1, y1, x2, y2 float64) {
+	cx1 := float64(from.X) + graphNodeWidth/2
+	cy1 := float64(from.Y) + graphNodeHeight/2
+	cx2 := float64(to.X) + graphNodeWidth/2
+	cy2 := float64(to.Y) + graphNodeHeight/2
+	dx, dy := cx2-cx1, cy2-cy1
+	if dx == 0 && dy == 0 {
+		return cx1, cy1, cx2, cy2
+	}
+	t := math.Min(
+		safeRatio(graphNodeWidth/2, math.Abs(dx)),
+		safeRatio(graphNodeHeight/2, math.Abs(dy)),
+	)
+	return cx1 + dx*t, cy1 + dy*t, cx2 - dx*t, cy2 - dy*t
+}
+
+// safeRatio делит числа, считая деление на ноль бесконечностью.
+func safeRatio(a, b float64) float64 {
+	if b == 0 {
+		return math.Inf(1)
+	}
+	return a / b
+}
+
+// graphEdgeColor возвращает цвет связи в зависимости от ее вида.
+func graphEdgeColor(kind LinkKind) color.RGBA {
+	switch kind {
+	case LinkKindRelated:
+		return color.RGBA{R: 0x2b, G: 0x6c, B: 0xb0, A: 0xff}
+	case LinkKindDependsOn:
+		return color.RGBA{R: 0xc0, G: 0x39, B: 0x2b, A: 0xff}
+	default:
+		return color.RGBA{R: 0x55, G: 0x55, B: 0x55, A: 0xff}
+	}
+}
+
+// renderGraphSVG рисует граф в формате SVG.
+func renderGraphSVG(g UserGraph) string {
+	layout := layoutGraph(g)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		layout.Width, layout.Height, layout.Width, layout.Height)
+	b.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse"><path d="M0,0 L10,5 L0,10 z" fill="context-stroke"/></marker></defs>` + "\n")
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", layout.Width, layout.Height)
+	for _, link := range g.Links {
+		x1, y1, x2, y2 := graphEdgeEnds(layout.Positions[link.FromID], layout.Positions[link.ToID])
+		c := graphEdgeColor(link.Kind)
+		marker := ` marker-end="url(#arrow)"`
+		if link.Kind.Symmetric() {
+			marker = ""
+		}
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#%02x%02x%02x" stroke-width="1.5"%s><title>%s</title></line>`+"\n",
+			x1, y1, x2, y2, c.R, c.G, c.B, marker, link.Kind)
+	}
+	for _, note := range g.Notes {
+		p := layout.Positions[note.ID]
+		label := graphLabel(note)
+		fmt.Fprintf(&b, `<g><rect x="%d" y="%d" width="%d" height="%d" rx="6" fill="#f4f6f8" stroke="#333"/>`,
+			p.X, p.Y, graphNodeWidth, graphNodeHeight)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" dominant-baseline="middle">%s</text></g>`+"\n",
+			p.X+8, p.Y+graphNodeHeight/2, html.EscapeString(label))
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// graphFace возвращает шрифт подписей PNG. Go Regular содержит кириллицу, поэтому
+// заголовки заметок рисуются без системных шрифтов.
+var graphFace = sync.OnceValues(func() (font.Face, error) {
+	parsed, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: graphFontSize, DPI: 72, Hinting: font.HintingFull})
+})
+
+// renderGraphPNG рисует граф в формате PNG. Узлы подписываются номерами и заголовками
+// заметок. Граф больше graphMaxWidth×graphMaxHeight не рисуется, чтобы не расходовать
+// память без ограничений.
+func renderGraphPNG(g UserGraph) ([]byte, error) {
+	layout := layoutGraph(g)
+	if layout.Width > graphMaxWidth || layout.Height > graphMaxHeight {
+		return nil, errGraphImageTooLarge
+	}
+	face, err := graphFace()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, layout.Width, layout.Height))
+	fillRect(img, img.Bounds(), color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	for _, link := range g.Links {
+		x1, y1, x2, y2 := graphEdgeEnds(layout.Positions[link.FromID], layout.Positions[link.ToID])
+		c := graphEdgeColor(link.Kind)
+		drawLine(img, x1, y1, x2, y2, c)
+		if !link.Kind.Symmetric() {
+			drawArrowHead(img, x1, y1, x2, y2, c)
+		}
+	}
+
+	border := color.RGBA{R: 0x33, G: 0x33, B: 0x33, A: 0xff}
+	fill := color.RGBA{R: 0xf4, G: 0xf6, B: 0xf8, A: 0xff}
+	for _, note := range g.Notes {
+		p := layout.Positions[note.ID]
+		box := image.Rect(p.X, p.Y, p.X+graphNodeWidth, p.Y+graphNodeHeight)
+		fillRect(img, box, border)
+		fillRect(img, box.Inset(1), fill)
+		drawLabel(img, face, graphLabel(note), box.Inset(6), border)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fillRect закрашивает прямоугольник заданным цветом.
+func fillRect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	rect = rect.Intersect(img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawLine рисует отрезок толщиной в два пикселя.
+func drawLine(img *image.RGBA, x1, y1, x2, y2 float64, c color.RGBA) {
+	steps := int(math.Max(math.Abs(x2-x1), math.Abs(y2-y1)))
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := int(math.Round(x1 + (x2-x1)*t))
+		y := int(math.Round(y1 + (y2-y1)*t))
+		fillRect(img, image.Rect(x, y, x+2, y+2), c)
+	}
+}
+
+// drawArrowHead рисует наконечник стрелки в конце отрезка.
+func drawArrowHead(img *image.RGBA, x1, y1, x2, y2 float64, c color.RGBA) {
+	angle := math.Atan2(y2-y1, x2-x1)
+	const size, spread = 10.0, math.Pi / 7
+	for _, delta := range []float64{spread, -spread} {
+		drawLine(img, x2, y2, x2-size*math.Cos(angle+delta), y2-size*math.Sin(angle+delta), c)
+	}
+}
+
+// drawLabel выводит подпись по центру узла по вертикали, обрезая ее по границам box.
+func drawLabel(img *image.RGBA, face font.Face, label string, box image.Rectangle, c color.RGBA) {
+	metrics := face.Metrics()
+	baseline := box.Min.Y + (box.Dy()+metrics.Ascent.Ceil()-metrics.Descent.Ceil())/2
+	drawer := font.Drawer{
+		Dst:  img.SubImage(box).(*image.RGBA),
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(box.Min.X, baseline),
+	}
+	drawer.DrawString(label)
+}
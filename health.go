@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthCheckTimeout ограничивает время одной проверки готовности.
+const healthCheckTimeout = 2 * time.Second
+
+// errDraining возвращается проверкой готовности во время остановки.
+var errDraining = errors.New("shutting down")
+
+// healthCheck описывает одну проверку зависимости.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// CheckResult описывает результат одной проверки в ответе /readyz.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport описывает ответ /healthz и /readyz.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health отвечает на проверки живости и готовности процесса.
+type Health struct {
+	mu       sync.Mutex
+	checks   []healthCheck
+	draining atomic.Bool
+}
+
+// NewHealth создает проверки готовности с базой данных и версией схемы.
+func NewHealth(store *NotesStore) *Health {
+	h := &Health{}
+	h.AddCheck("database", store.Ping)
+	h.AddCheck("migrations", func(ctx context.Context) error {
+		current, err := store.MigrationsCurrent(ctx)
+		if err != nil {
+			return err
+		}
+		if !current {
+			return errors.New("schema version does not match the binary")
+		}
+		return nil
+	})
+	return h
+}
+
+// AddCheck добавляет проверку готовности.
+func (h *Health) AddCheck(name string, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// StartDraining переводит готовность в состояние отказа, чтобы балансировщик
+// перестал направлять запросы до остановки сервера.
+func (h *Health) StartDraining() {
+	h.draining.Store(true)
+}
+
+// Ready выполняет все проверки параллельно и возвращает отчет.
+func (h *Health) Ready(ctx context.Context) HealthReport {
+	h.mu.Lock()
+	checks := append([]healthCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks)+1)}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := CheckResult{Status: "ok"}
+			if err := c.check(ctx); err != nil {
+				result = CheckResult{Status: "fail", Error: err.Error()}
+			}
+			mu.Lock()
+			report.Checks[c.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if h.draining.Load() {
+		report.Checks["shutdown"] = CheckResult{Status: "fail", Error: errDraining.Error()}
+	}
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+// LivenessHandler отвечает на /healthz, пока процесс работает.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, HealthReport{Status: "ok"})
+	})
+}
+
+// ReadinessHandler отвечает на /readyz: 200, если все проверки успешны, иначе 503.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxIdempotencyKeyLength ограничивает длину заголовка Idempotency-Key.
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyReused возвращается, если ключ уже использован с другим запросом.
+var errIdempotencyKeyReused = newConflict("idempotency_key_reused", "Idempotency-Key was already used with a different request")
+
+// errInvalidIdempotencyKey возвращается для слишком длинного ключа или ключа с непечатными символами.
+var errInvalidIdempotencyKey = newValidation("invalid_idempotency_key", "Idempotency-Key must be 1 to 255 printable ASCII characters")
+
+// IdempotencyRecord хранит ответ на запрос с ключом идемпотентности. Вместе с телом
+// сохраняются заголовки, которые клиент использует дальше: ETag для условных
+// запросов и Location созданного ресурса.
+type IdempotencyRecord struct {
+	UserID       int64     `gorm:"primaryKey;autoIncrement:false"`
+	Key          string    `gorm:"primaryKey;type:varchar(255)"`
+	RequestHash  string    `gorm:"type:varchar(64);not null"`
+	StatusCode   int       `gorm:"not null"`
+	ContentType  string    `gorm:"not null"`
+	ETag         string    `gorm:"column:etag;not null"`
+	Location     string    `gorm:"not null"`
+	ResponseBody []byte    `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"not null"`
+}
+
+// TableName возвращает имя таблицы ключей идемпотентности.
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// errIdempotentRequestFailed отменяет изменения запроса, ответившего ошибкой.
+var errIdempotentRequestFailed = errors.New("idempotent request failed")
+
+// Idempotent выполняет fn не более одного раза для пары пользователь и ключ в пределах ttl.
+// fn получает хранилище, привязанное к той же транзакции, что и запись ключа, поэтому
+// изменения запроса и сохраненный ответ фиксируются вместе, а запрос занимает одно
+// соединение. Параллельные запросы с тем же ключом ждут друг друга на advisory-блокировке.
+// Если ключ уже использован, возвращается сохраненный ответ и replayed=true, а при
+// другом requestHash — errIdempotencyKeyReused. Изменения запроса, ответившего кодом
+// 4xx или 5xx, отменяются. Ответы 5xx не сохраняются, чтобы повтор после сбоя выполнил
+// запрос заново. Подписчики OnChange узнают об изменениях после фиксации.
+func (s *NotesStore) Idempotent(ctx context.Context, userID int64, key, requestHash string, ttl time.Duration, fn func(store *NotesStore) (IdempotencyRecord, error)) (IdempotencyRecord, bool, error) {
+	var (
+		result   IdempotencyRecord
+		replayed bool
+		changed  []int64
+	)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", idempotencyLockID(userID, key)).Error; err != nil {
+			return err
+		}
+
+		var existing IdempotencyRecord
+		err := tx.Where("user_id = ? AND key = ? AND created_at > ?", userID, key, time.Now().Add(-ttl)).
+			First(&existing).Error
+		switch {
+		case err == nil:
+			if existing.RequestHash != requestHash {
+				return errIdempotencyKeyReused
+			}
+			result, replayed = existing, true
+			return nil
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		// Запрос выполняется в точке сохранения: ошибка запроса не прерывает транзакцию,
+		// и ответ с ошибкой все равно можно сохранить.
+		var record IdempotencyRecord
+		err = tx.Transaction(func(savepoint *gorm.DB) error {
+			store := s.inTx(savepoint)
+			store.onChange = func(userID int64) { changed = append(changed, userID) }
+			var err error
+			record, err = fn(store)
+			if err == nil && record.StatusCode >= http.StatusBadRequest {
+				return errIdempotentRequestFailed
+			}
+			return err
+		})
+		if errors.Is(err, errIdempotentRequestFailed) {
+			changed = nil
+		} else if err != nil {
+			return err
+		}
+		result = record
+		if record.StatusCode >= http.StatusInternalServerError {
+			return nil
+		}
+		now := time.Now()
+		record.UserID, record.Key, record.RequestHash, record.CreatedAt = userID, key, requestHash, now
+		// Вместе с просроченной записью этого ключа удаляются и остальные просроченные ключи пользователя.
+		if err := tx.Where("user_id = ? AND (key = ? OR created_at <= ?)", userID, key, now.Add(-ttl)).
+			Delete(&IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	for _, id := range changed {
+		s.changed(id)
+	}
+	return result, replayed, nil
+}
+
+// idempotencyLockID вычисляет ключ advisory-блокировки для пары пользователь и ключ.
+func idempotencyLockID(userID int64, key string) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "idempotency:%d:%s", userID, key)
+	return int64(h.Sum64())
+}
+
+// validIdempotencyKey проверяет длину и состав ключа.
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyRecorder буферизует ответ обработчика, чтобы сохранить его вместе с ключом.
+type idempotencyRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header возвращает заголовки буферизованного ответа.
+func (r *idempotencyRecorder) Header() http.Header {
+	return r.header
+}
+
+// WriteHeader запоминает код ответа.
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Write дописывает тело ответа в буфер.
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
+
+// contextWithStore возвращает контекст запроса с хранилищем, привязанным к его транзакции.
+func contextWithStore(ctx context.Context, store *NotesStore) context.Context {
+	return context.WithValue(ctx, storeKey, store)
+}
+
+// storeFor возвращает хранилище для запроса: привязанное к транзакции ключа
+// идемпотентности, если запрос выполняется внутри нее, иначе общее хранилище API.
+// Обработчики, обернутые в idempotent, обращаются к базе только через него.
+func (a *API) storeFor(r *http.Request) *NotesStore {
+	if store, ok := r.Context().Value(storeKey).(*NotesStore); ok {
+		return store
+	}
+	return a.store
+}
+
+// idempotent обрабатывает заголовок Idempotency-Key для создающих запросов.
+// Без заголовка запрос выполняется как обычно. С заголовком обработчик работает
+// в транзакции, в которой сохраняется ответ, см. NotesStore.Idempotent.
+func (a *API) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, r, errInvalidIdempotencyKey)
+			return
+		}
+		userID, err := userIDFromQuery(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = errPayloadTooLarge
+			}
+			writeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		// Устаревший путь и путь /v1 описывают один и тот же запрос.
+		sum := sha256.Sum256([]byte(r.Method + " " + strings.TrimPrefix(r.URL.Path, apiPrefix) + "\n" + string(body)))
+		requestHash := hex.EncodeToString(sum[:])
+
+		recorder := &idempotencyRecorder{header: make(http.Header)}
+		record, replayed, err := a.store.Idempotent(r.Context(), userID, key, requestHash, time.Duration(a.idempotencyTTL.Load()),
+			func(store *NotesStore) (IdempotencyRecord, error) {
+				next(recorder, r.WithContext(contextWithStore(r.Context(), store)))
+				if recorder.status == 0 {
+					recorder.status = http.StatusOK
+				}
+				return IdempotencyRecord{
+					StatusCode:   recorder.status,
+					ContentType:  recorder.header.Get("Content-Type"),
+					ETag:         recorder.header.Get("ETag"),
+					Location:     recorder.header.Get("Location"),
+					ResponseBody: recorder.body.Bytes(),
+				}, nil
+			})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		} else {
+			for name, values := range recorder.header {
+				w.Header()[name] = values
+			}
+		}
+		for name, value := range map[string]string{
+			"Content-Type": record.ContentType,
+			"ETag":         record.ETag,
+			"Location":     record.Location,
+		} {
+			if value != "" {
+				w.Header().Set(name, value)
+			}
+		}
+		w.WriteHeader(record.StatusCode)
+		_, _ = w.Write(record.ResponseBody)
+	}
+}
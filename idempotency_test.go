@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// postWithKey отправляет POST с телом JSON и заголовком Idempotency-Key.
+func postWithKey(t *testing.T, server *httptest.Server, path, key, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("api", "secret")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestIdempotentCreateReplaysHeaders(t *testing.T) {
+	store, userID := testStore(t)
+	var changes atomic.Int32
+	store.OnChange(func(int64) { changes.Add(1) })
+	server := httptest.NewServer(NewAPI(store, "api", "secret").Handler())
+	defer server.Close()
+
+	path := "/v1/notes?user_id=" + strconv.FormatInt(userID, 10)
+	first, firstBody := postWithKey(t, server, path, "create-1", `{"text":"once"}`)
+	if first.StatusCode != http.StatusCreated || first.Header.Get("Location") == "" || first.Header.Get("ETag") == "" {
+		t.Fatalf("first response = %d %v %s", first.StatusCode, first.Header, firstBody)
+	}
+	second, secondBody := postWithKey(t, server, path, "create-1", `{"text":"once"}`)
+	if second.Header.Get("Idempotent-Replayed") != "true" || secondBody != firstBody {
+		t.Fatalf("second response = %d %v %s", second.StatusCode, second.Header, secondBody)
+	}
+	for _, name := range []string{"Location", "ETag", "Content-Type"} {
+		if second.Header.Get(name) != first.Header.Get(name) {
+			t.Errorf("replayed %s = %q, want %q", name, second.Header.Get(name), first.Header.Get(name))
+		}
+	}
+
+	notes, err := store.ListNotes(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 {
+		t.Errorf("notes = %+v, want one", notes)
+	}
+	if changes.Load() != 1 {
+		t.Errorf("change notifications = %d, want 1", changes.Load())
+	}
+
+	reused, _ := postWithKey(t, server, path, "create-1", `{"text":"other"}`)
+	if reused.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reused key status = %d, want %d", reused.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotentFailedRequestLeavesNoChanges(t *testing.T) {
+	store, userID := testStore(t)
+	server := httptest.NewServer(NewAPI(store, "api", "secret").Handler())
+	defer server.Close()
+
+	// Вторая операция ссылается на несуществующую заметку, и пакет atomic отменяется.
+	path := "/v1/notes:batch?user_id=" + strconv.FormatInt(userID, 10)
+	body := `{"mode":"atomic","operations":[{"op":"create","text":"kept?"},{"op":"delete","id":2147483647,"version":1}]}`
+	for range 2 {
+		resp, data := postWithKey(t, server, path, "batch-1", body)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d: %s", resp.StatusCode, data)
+		}
+	}
+	notes, err := store.ListNotes(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Errorf("failed batch left notes: %+v", notes)
+	}
+}
+
+func TestIdempotentRejectsInvalidKey(t *testing.T) {
+	server := httptest.NewServer(NewAPI(&NotesStore{}, "api", "secret").Handler())
+	defer server.Close()
+
+	resp, body := postWithKey(t, server, "/v1/notes?user_id=1", "bad\tkey", `{"text":"x"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "invalid_idempotency_key") {
+		t.Errorf("response = %d %s", resp.StatusCode, body)
+	}
+}
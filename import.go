@@ -0,0 +1,509 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxImportBytes ограничивает размер файла загрузки и суммарный размер файлов в zip.
+	maxImportBytes = 20 << 20
+	// maxImportFiles ограничивает число заметок в zip с Markdown.
+	maxImportFiles = 10000
+	// maxImportWarnings ограничивает число предупреждений в отчете о загрузке.
+	maxImportWarnings = 20
+	// importPreviewSize — сколько заметок показывается в отчете для предпросмотра.
+	importPreviewSize = 5
+)
+
+// ImportFormat — формат файла загрузки.
+type ImportFormat string
+
+const (
+	// ImportAuto определяет формат по содержимому файла.
+	ImportAuto ImportFormat = "auto"
+	// ImportJSON — выгрузка этого сервиса в формате json.
+	ImportJSON ImportFormat = "json"
+	// ImportMarkdown — zip с файлами Markdown, например хранилище Obsidian.
+	ImportMarkdown ImportFormat = "markdown"
+	// ImportTelegram — result.json из экспорта чата Telegram Desktop.
+	ImportTelegram ImportFormat = "telegram"
+)
+
+// Valid проверяет, что формат известен.
+func (f ImportFormat) Valid() bool {
+	switch f {
+	case ImportAuto, ImportJSON, ImportMarkdown, ImportTelegram:
+		return true
+	}
+	return false
+}
+
+// errImportDryRun откатывает транзакцию загрузки в режиме проверки.
+var errImportDryRun = errors.New("import dry run")
+
+// errImportTooLarge возвращается, если файл или распакованный архив больше maxImportBytes.
+var errImportTooLarge = newValidation("import_too_large", fmt.Sprintf("import must not exceed %d bytes and %d notes", maxImportBytes, maxImportFiles))
+
+// invalidImport возвращает ошибку разбора файла загрузки.
+func invalidImport(detail string) error {
+	return newValidation("invalid_import", detail)
+}
+
+// ImportSource — разобранный файл загрузки. Номера заметок в Dump локальны для файла
+// и при загрузке заменяются новыми.
+type ImportSource struct {
+	Format   ImportFormat
+	Dump     UserExport
+	Warnings []string
+}
+
+// warn добавляет предупреждение, не превышая maxImportWarnings.
+func (s *ImportSource) warn(format string, args ...any) {
+	switch {
+	case len(s.Warnings) < maxImportWarnings:
+		s.Warnings = append(s.Warnings, fmt.Sprintf(format, args...))
+	case len(s.Warnings) == maxImportWarnings:
+		s.Warnings = append(s.Warnings, "further warnings omitted")
+	}
+}
+
+// Import разбирает файл и загружает его в заметки пользователя. При dryRun данные
+// записываются в транзакции, которая затем откатывается, поэтому отчет точно
+// совпадает с результатом настоящей загрузки.
+func (s *NotesStore) Import(ctx context.Context, userID int64, data []byte, format ImportFormat, dryRun bool) (ImportSummary, error) {
+	source, err := ParseImport(data, format)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	summary, err := s.ImportUser(ctx, userID, source.Dump, dryRun)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	summary.Format = source.Format
+	summary.Warnings = source.Warnings
+	for _, note := range source.Dump.Notes {
+		if len(summary.Preview) == importPreviewSize {
+			break
+		}
+		summary.Preview = append(summary.Preview, previewText(note.Text, 80))
+	}
+	return summary, nil
+}
+
+// handleImport загружает файл из тела запроса: выгрузку json, zip с Markdown или
+// result.json Telegram. С dry_run=true отвечает 200 с отчетом без изменений, иначе 201.
+func (a *API) handleImport(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	format := ImportAuto
+	if value := query.Get("format"); value != "" {
+		format = ImportFormat(value)
+	}
+	if !format.Valid() {
+		writeError(w, r, invalidParameter("format"))
+		return
+	}
+	dryRun := query.Get("dry_run")
+	if dryRun != "" && dryRun != "true" && dryRun != "false" {
+		writeError(w, r, invalidParameter("dry_run"))
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxImportBytes+1))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = errPayloadTooLarge
+		}
+		writeError(w, r, err)
+		return
+	}
+	summary, err := a.storeFor(r).Import(r.Context(), userID, data, format, dryRun == "true")
+	if err != nil {
+		writeError(w, r, fmt.Errorf("import: %w", err))
+		return
+	}
+	status := http.StatusCreated
+	if summary.DryRun {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, summary)
+}
+
+// ParseImport разбирает файл загрузки. Для ImportAuto формат определяется по содержимому:
+// zip считается хранилищем Markdown, JSON с messages или chats — экспортом Telegram,
+// остальной JSON — выгрузкой этого сервиса.
+func ParseImport(data []byte, format ImportFormat) (ImportSource, error) {
+	if len(data) > maxImportBytes {
+		return ImportSource{}, errImportTooLarge
+	}
+	if format == ImportAuto || format == "" {
+		format = detectImportFormat(data)
+	}
+	source := ImportSource{Format: format, Dump: UserExport{Version: exportFormatVersion}}
+	var err error
+	switch format {
+	case ImportJSON:
+		err = parseJSONImport(data, &source)
+	case ImportMarkdown:
+		err = parseMarkdownImport(data, &source)
+	case ImportTelegram:
+		err = parseTelegramImport(data, &source)
+	default:
+		err = invalidImport(fmt.Sprintf("unknown import format %q", format))
+	}
+	if err != nil {
+		return ImportSource{}, err
+	}
+	if len(source.Dump.Notes) == 0 {
+		return ImportSource{}, invalidImport("file contains no notes")
+	}
+	return source, nil
+}
+
+// detectImportFormat определяет формат по сигнатуре zip и ключам верхнего уровня JSON.
+func detectImportFormat(data []byte) ImportFormat {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return ImportMarkdown
+	}
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) == nil {
+		if _, ok := keys["notes"]; !ok {
+			_, messages := keys["messages"]
+			_, chats := keys["chats"]
+			if messages || chats {
+				return ImportTelegram
+			}
+		}
+	}
+	return ImportJSON
+}
+
+// parseJSONImport читает выгрузку этого сервиса.
+func parseJSONImport(data []byte, source *ImportSource) error {
+	if err := json.Unmarshal(data, &source.Dump); err != nil {
+		return invalidImport("invalid JSON export: " + err.Error())
+	}
+	if source.Dump.Version > exportFormatVersion {
+		return invalidImport(fmt.Sprintf("export version %d is newer than supported %d", source.Dump.Version, exportFormatVersion))
+	}
+	return nil
+}
+
+var (
+	// wikilinkPattern находит [[Имя]], [[Имя|подпись]] и [[Имя#раздел]]; группа 1 — имя заметки.
+	wikilinkPattern = regexp.MustCompile(`\[\[([^\[\]|#^]+)(?:[#^][^\[\]|]*)?(?:\|[^\[\]]*)?\]\]`)
+	// linkLinePattern описывает строку раздела «Связи» из выгрузки markdown: «- kind: [[Имя]]».
+	linkLinePattern = regexp.MustCompile(`^- ([a-z_]+): \[\[([^\[\]|#^]+)\]\]$`)
+)
+
+// markdownLinksHeading — заголовок раздела связей в выгрузке markdown.
+const markdownLinksHeading = "## Связи"
+
+// markdownFile — заметка из zip до разрешения ссылок.
+type markdownFile struct {
+	name  string
+	note  Note
+	links []NoteLink
+	// targets — имена заметок из ссылок в том же порядке, что и links.
+	targets []string
+}
+
+// parseMarkdownImport читает zip с файлами .md. Имя файла без расширения — имя заметки
+// для [[wikilinks]], ссылки в тексте становятся связями reference, строки раздела
+// «Связи» из выгрузки markdown — связями указанного вида. Блок front matter
+// не попадает в текст; из него берутся даты created и updated.
+func parseMarkdownImport(data []byte, source *ImportSource) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return invalidImport("invalid zip archive: " + err.Error())
+	}
+	entries := append([]*zip.File(nil), archive.File...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	var (
+		files []markdownFile
+		total uint64
+	)
+	for _, entry := range entries {
+		if entry.FileInfo().IsDir() || !strings.EqualFold(path.Ext(entry.Name), ".md") || hiddenPath(entry.Name) {
+			continue
+		}
+		total += entry.UncompressedSize64
+		if total > maxImportBytes || len(files) == maxImportFiles {
+			return errImportTooLarge
+		}
+		content, err := readZipFile(entry)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(path.Base(entry.Name), path.Ext(entry.Name))
+		modified := entry.Modified
+		if modified.Year() < 1981 {
+			// Архивы без времени изменения хранят нулевую дату DOS — 30 ноября 1979 года.
+			modified = time.Time{}
+		}
+		file := parseMarkdownNote(name, content, modified)
+		if file.note.Text == "" {
+			source.warn("%s: empty note skipped", entry.Name)
+			continue
+		}
+		file.note.ID = uint(len(files) + 1)
+		files = append(files, file)
+	}
+
+	// Obsidian ищет заметку по имени файла в любой папке, без учета регистра.
+	byName := make(map[string]uint, len(files))
+	for _, file := range files {
+		key := strings.ToLower(file.name)
+		if _, ok := byName[key]; ok {
+			source.warn("%s: duplicate note name, links resolve to the first one", file.name)
+			continue
+		}
+		byName[key] = file.note.ID
+	}
+	for _, file := range files {
+		source.Dump.Notes = append(source.Dump.Notes, file.note)
+		for i, link := range file.links {
+			target := strings.TrimSpace(file.targets[i])
+			// Связь на отсутствующую заметку остается с нулевым номером и пропускается при загрузке.
+			link.ToID = byName[strings.ToLower(target)]
+			if link.ToID == 0 {
+				source.warn("%s: link to missing note [[%s]]", file.name, target)
+			}
+			link.ID, link.FromID = uint(len(source.Dump.Links)+1), file.note.ID
+			source.Dump.Links = append(source.Dump.Links, link)
+		}
+	}
+	return nil
+}
+
+// hiddenPath сообщает, лежит ли файл в скрытой или служебной папке, например .obsidian или __MACOSX.
+func hiddenPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// readZipFile читает файл архива, проверяя фактический размер, а не только заголовок.
+func readZipFile(entry *zip.File) (string, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return "", invalidImport(entry.Name + ": " + err.Error())
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxImportBytes+1))
+	if err != nil {
+		return "", invalidImport(entry.Name + ": " + err.Error())
+	}
+	if len(content) > maxImportBytes {
+		return "", errImportTooLarge
+	}
+	return string(content), nil
+}
+
+// parseMarkdownNote разбирает один файл .md. Если у файла нет front matter с id
+// (то есть он не из выгрузки markdown) и текст не начинается с имени файла,
+// имя становится первой строкой заметки.
+func parseMarkdownNote(name, content string, modified time.Time) markdownFile {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	meta, body := splitFrontMatter(content)
+
+	var (
+		lines     []string
+		links     []NoteLink
+		targets   []string
+		inSection bool
+	)
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == markdownLinksHeading:
+			inSection = true
+			continue
+		case inSection && strings.HasPrefix(trimmed, "#"):
+			inSection = false
+		case inSection:
+			if match := linkLinePattern.FindStringSubmatch(trimmed); match != nil && LinkKind(match[1]).Valid() {
+				links = append(links, NoteLink{Kind: LinkKind(match[1])})
+				targets = append(targets, match[2])
+				continue
+			}
+			if trimmed == "" {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+	if first, _, _ := strings.Cut(text, "\n"); text != "" && meta["id"] == "" && strings.TrimSpace(first) != name {
+		text = name + "\n\n" + text
+	}
+	for _, match := range wikilinkPattern.FindAllStringSubmatch(text, -1) {
+		links = append(links, NoteLink{Kind: LinkKindReference})
+		targets = append(targets, match[1])
+	}
+
+	created := parseImportTime(meta["created"], modified)
+	note := Note{
+		Text:      text,
+		Status:    NoteStatusActive,
+		CreatedAt: created,
+		UpdatedAt: parseImportTime(meta["updated"], created),
+	}
+	return markdownFile{name: name, note: note, links: links, targets: targets}
+}
+
+// splitFrontMatter отделяет блок front matter между строками --- и возвращает
+// его поля вида key: value и оставшийся текст.
+func splitFrontMatter(content string) (map[string]string, string) {
+	meta := make(map[string]string)
+	if !strings.HasPrefix(content, "---\n") {
+		return meta, content
+	}
+	header, body, found := strings.Cut(content[len("---\n"):], "\n---")
+	if !found {
+		return meta, content
+	}
+	for _, line := range strings.Split(header, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			meta[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	_, body, _ = strings.Cut(body, "\n")
+	return meta, body
+}
+
+// parseImportTime разбирает время в RFC 3339 или возвращает fallback.
+func parseImportTime(value string, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	if fallback.IsZero() {
+		return time.Now().UTC()
+	}
+	return fallback
+}
+
+// telegramExport описывает result.json из Telegram Desktop: экспорт одного чата
+// содержит messages, экспорт всех данных — chats.list.
+type telegramExport struct {
+	Messages []telegramMessage `json:"messages"`
+	Chats    struct {
+		List []struct {
+			Messages []telegramMessage `json:"messages"`
+		} `json:"list"`
+	} `json:"chats"`
+}
+
+// telegramMessage описывает сообщение экспорта Telegram. Text — строка или массив
+// из строк и объектов с полем text для форматированных фрагментов.
+type telegramMessage struct {
+	ID               int64           `json:"id"`
+	Type             string          `json:"type"`
+	Date             string          `json:"date"`
+	DateUnixtime     string          `json:"date_unixtime"`
+	Text             json.RawMessage `json:"text"`
+	ReplyToMessageID int64           `json:"reply_to_message_id"`
+}
+
+// parseTelegramImport превращает каждое сообщение с текстом в заметку. Ответ на
+// сообщение становится связью reference с исходным сообщением.
+func parseTelegramImport(data []byte, source *ImportSource) error {
+	var export telegramExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return invalidImport("invalid Telegram export: " + err.Error())
+	}
+	chats := [][]telegramMessage{export.Messages}
+	for _, chat := range export.Chats.List {
+		chats = append(chats, chat.Messages)
+	}
+
+	skipped := 0
+	for _, messages := range chats {
+		// Номера сообщений уникальны только внутри чата.
+		ids := make(map[int64]uint, len(messages))
+		for _, message := range messages {
+			text := strings.TrimSpace(telegramText(message.Text))
+			if message.Type != "message" || text == "" {
+				skipped++
+				continue
+			}
+			if len(source.Dump.Notes) == maxImportFiles {
+				return errImportTooLarge
+			}
+			created := telegramTime(message)
+			note := Note{ID: uint(len(source.Dump.Notes) + 1), Text: text, Status: NoteStatusActive, CreatedAt: created, UpdatedAt: created}
+			source.Dump.Notes = append(source.Dump.Notes, note)
+			ids[message.ID] = note.ID
+			if original, ok := ids[message.ReplyToMessageID]; ok && message.ReplyToMessageID != 0 {
+				source.Dump.Links = append(source.Dump.Links, NoteLink{
+					ID:     uint(len(source.Dump.Links) + 1),
+					FromID: note.ID,
+					ToID:   original,
+					Kind:   LinkKindReference,
+				})
+			}
+		}
+	}
+	if skipped > 0 {
+		source.warn("%d message(s) without text or service messages skipped", skipped)
+	}
+	return nil
+}
+
+// telegramText собирает текст сообщения из строки или массива фрагментов.
+func telegramText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(part, &text) == nil {
+			b.WriteString(text)
+		} else if json.Unmarshal(part, &entity) == nil {
+			b.WriteString(entity.Text)
+		}
+	}
+	return b.String()
+}
+
+// telegramTime возвращает время сообщения: date_unixtime, если оно есть, иначе date
+// без часового пояса, который Telegram Desktop записывает в местном времени и здесь считается UTC.
+func telegramTime(message telegramMessage) time.Time {
+	if seconds, err := strconv.ParseInt(message.DateUnixtime, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC()
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05", message.Date); err == nil {
+		return t
+	}
+	return time.Now().UTC()
+}
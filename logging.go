@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// LogFormat определяет формат вывода логов.
+type LogFormat string
+
+const (
+	// LogFormatText выводит логи в формате key=value.
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON выводит логи построчно в JSON.
+	LogFormatJSON LogFormat = "json"
+)
+
+// Valid проверяет, что формат известен.
+func (f LogFormat) Valid() bool {
+	return f == LogFormatText || f == LogFormatJSON
+}
+
+// contextKey используется для значений, которые middleware кладет в контекст запроса.
+type contextKey int
+
+const (
+	// requestIDKey хранит идентификатор запроса или обновления Telegram.
+	requestIDKey contextKey = iota
+	// spanKey хранит идентификаторы текущего спана трассировки.
+	spanKey
+	// legacyRouteKey отмечает запросы к устаревшим путям без префикса /v1.
+	legacyRouteKey
+	// storeKey хранит хранилище, привязанное к транзакции запроса с Idempotency-Key.
+	storeKey
+)
+
+// withRequestID возвращает контекст с идентификатором запроса.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestIDFromContext возвращает идентификатор запроса из контекста.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID генерирует случайный идентификатор запроса.
+func newRequestID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(raw)
+}
+
+// contextHandler добавляет в каждую запись идентификаторы запроса и трассы из контекста.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle дополняет запись атрибутами request_id и trace_id.
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if id := traceIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("trace_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs сохраняет обертку при добавлении атрибутов.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup сохраняет обертку при добавлении группы.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogging настраивает логгер по умолчанию с уровнем из logLevel.
+// Вывод пакета log тоже попадает в этот логгер.
+func setupLogging(w io.Writer, format LogFormat, level slog.Level) {
+	logLevel.Set(level)
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// slowQueryThreshold задает длительность, после которой запрос считается медленным.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormSlogLogger передает логи GORM в slog вместе с контекстом запроса.
+type gormSlogLogger struct {
+	level gormlogger.LogLevel
+}
+
+// newGormLogger создает адаптер логгера GORM.
+func newGormLogger() gormlogger.Interface {
+	return gormSlogLogger{level: gormlogger.Info}
+}
+
+// LogMode возвращает копию логгера с другим уровнем.
+func (l gormSlogLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	l.level = level
+	return l
+}
+
+// Info пишет информационное сообщение GORM.
+func (l gormSlogLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+// Warn пишет предупреждение GORM.
+func (l gormSlogLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+// Error пишет ошибку GORM.
+func (l gormSlogLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+// Trace пишет выполненный SQL-запрос: ошибки — как error, медленные — как warn,
+// остальные — на уровне debug.
+func (l gormSlogLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	level := slog.LevelDebug
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		level = slog.LevelError
+	case elapsed > slowQueryThreshold && l.level >= gormlogger.Warn:
+		level = slog.LevelWarn
+	}
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{"component", "gorm", "duration", elapsed, "rows", rows, "sql", sql}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Log(ctx, level, "sql query", attrs...)
+}
@@ -2,54 +2,131 @@ package main
 
 import (
 	"context"
-	"log"
-	"net/http"
+	"errors"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
 )
 
-// main запускает HTTP API и Telegram-бота.
+// main разбирает подкоманду и выполняет ее. Без аргументов запускаются HTTP API и Telegram-бот.
 func main() {
-	config := LoadConfig()
-
-	store, err := NewNotesStore(config.DatabaseURL)
-	if err != nil {
-		log.Fatalf("cannot init store: %v", err)
+	if err := runCLI(os.Args[1:]); err != nil {
+		slog.Error("command failed", "error", err)
+		os.Exit(1)
 	}
-	defer func() {
-		if err := store.Close(); err != nil {
-			log.Printf("cannot close store: %v", err)
-		}
-	}()
+}
 
-	api := NewAPI(store, config.APIUser, config.APIPassword)
-	server := &http.Server{
-		Addr:    config.HTTPAddr,
-		Handler: api.Handler(),
+// runServe запускает компоненты, выбранные режимом, до получения сигнала завершения.
+// В режиме all бот без BOT_TOKEN не запускается, а API продолжает работать.
+// По SIGHUP конфигурация перечитывается через reload и применяется без перезапуска.
+// Остановка идет по шагам: прием обновлений и запросов прекращается, начатая работа
+// доводится до конца в пределах SHUTDOWN_TIMEOUT, затем выполняются действия lifecycle,
+// и последней закрывается база данных. Повторный сигнал завершает процесс сразу.
+func runServe(config Config, reload func() (Config, error)) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.APIUser == "" && config.Mode != ServeModeBot {
+		slog.Warn("API_USER is not set, HTTP API accepts only tokens")
 	}
 
-	bot := NewTelegramBot(store, config.BotToken, config.BotLogin, config.BotPassword)
+	store, err := NewNotesStore(config.DatabaseURL, config.LinkPolicy)
+	if err != nil {
+		return err
+	}
+	lc := &lifecycle{}
+	stop := func() error {
+		stopCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		return lc.Stop(stopCtx)
+	}
+	defer stop()
+	lc.OnStop("database", func(context.Context) error { return store.Close() })
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := notifyShutdown(context.Background())
 	defer cancel()
 
-	go func() {
-		log.Printf("HTTP server started on %s", config.HTTPAddr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("http server error: %v", err)
+	if err := appMetrics.registerStoreMetrics(store); err != nil {
+		return err
+	}
+	if tracer := setupTracing(config); tracer != nil {
+		if err := registerQueryTracing(store.db); err != nil {
+			return err
 		}
-	}()
+		lc.OnStop("traces", tracer.Shutdown)
+		slog.Info("tracing enabled", "exporter", config.TraceExporter, "sample_ratio", config.TraceSampleRatio)
+	}
 
-	go func() {
-		if err := bot.Start(ctx); err != nil {
-			log.Fatalf("bot error: %v", err)
+	var (
+		services []service
+		api      *API
+		bot      *TelegramBot
+	)
+	if config.Mode == ServeModeAll || config.Mode == ServeModeAPI {
+		api = NewAPI(store, config.APIUser, config.APIPassword)
+		api.SetMaxBodyBytes(config.MaxBodyBytes)
+		api.SetIdempotencyTTL(config.IdempotencyTTL)
+		if config.MetricsToken != "" {
+			api.EnableMetrics(config.MetricsToken)
+		} else {
+			slog.Info("METRICS_TOKEN is not set, /metrics is disabled")
 		}
-	}()
 
-	<-ctx.Done()
-	log.Printf("shutdown requested")
-	if err := server.Shutdown(context.Background()); err != nil {
-		log.Printf("http shutdown error: %v", err)
+		// Изменения из бота и API этого процесса доходят до потоков /v1/events напрямую,
+		// изменения других процессов — через LISTEN, если он включен.
+		events := NewEventBroker()
+		store.OnChange(events.Publish)
+		api.EnableEvents(events, config.EventsHeartbeat)
+		appMetrics.RegisterGauge("events_subscribers", "Open /v1/events streams.", func(context.Context) ([]gaugeSample, error) {
+			return []gaugeSample{{Value: float64(events.Subscribers())}}, nil
+		})
+		if config.EventsListen {
+			services = append(services, &eventListenerService{broker: events, databaseURL: config.DatabaseURL})
+		}
+	}
+	if config.Mode == ServeModeAll && config.BotToken == "" {
+		slog.Warn("bot is disabled", "reason", errMissingBotToken)
+	} else if config.Mode == ServeModeAll || config.Mode == ServeModeBot {
+		bot = NewTelegramBot(store, config.BotToken, config.BotLogin, config.BotPassword)
+		bot.pollTimeout = config.BotPollTimeout
+		bot.debug = config.BotDebug
+		bot.workers = config.BotWorkers
+		bot.drainTimeout = config.ShutdownTimeout
+		services = append(services, botService{bot: bot})
+	}
+	// Очередь вебхуков хранится в базе и разбирается любым процессом, в том числе только с ботом.
+	services = append(services, newWebhookDispatcher(store, config))
+	if api != nil {
+		health := NewHealth(store)
+		if bot != nil {
+			health.AddCheck("bot", func(ctx context.Context) error {
+				if !bot.Ready() {
+					return errors.New("bot is not polling updates")
+				}
+				return nil
+			})
+		}
+		api.EnableHealth(health)
+		server := newAPIService(config, api.Handler(), health)
+		// Потоки событий закрываются в начале остановки, иначе Shutdown ждал бы их до тайм-аута.
+		server.server.RegisterOnShutdown(api.events.Close)
+		services = append([]service{server}, services...)
 	}
+
+	go watchReload(ctx, config, reload, func(updated Config) {
+		logLevel.Set(updated.LogLevel)
+		if api != nil {
+			api.SetCredentials(updated.APIUser, updated.APIPassword)
+			api.SetMaxBodyBytes(updated.MaxBodyBytes)
+			api.SetIdempotencyTTL(updated.IdempotencyTTL)
+		}
+		if bot != nil {
+			bot.SetCredentials(updated.BotLogin, updated.BotPassword)
+		}
+	})
+
+	slog.Info("starting", "mode", config.Mode)
+	err = runServices(ctx, services)
+	err = errors.Join(err, stop())
+	slog.Info("shutdown complete")
+	return err
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// metricsScrapeTimeout ограничивает время сбора метрик из базы данных.
+const metricsScrapeTimeout = 5 * time.Second
+
+// defaultLatencyBuckets задает границы гистограмм длительности в секундах.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec хранит счетчики с метками.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newCounterVec создает счетчик с заданными метками.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Inc увеличивает счетчик для значений меток на единицу.
+func (c *counterVec) Inc(labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// write выводит счетчик в текстовом формате Prometheus.
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogramSeries хранит наблюдения одной комбинации меток.
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogramVec хранит гистограммы с метками.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// newHistogramVec создает гистограмму с заданными метками.
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// Observe добавляет наблюдение для значений меток.
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// write выводит гистограмму в текстовом формате Prometheus.
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, series.count)
+	}
+}
+
+// gaugeSample описывает одно значение метрики типа gauge.
+type gaugeSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// gaugeFunc вычисляет значения метрики в момент сбора.
+type gaugeFunc struct {
+	name    string
+	help    string
+	collect func(ctx context.Context) ([]gaugeSample, error)
+}
+
+// write выводит значения gauge в текстовом формате Prometheus.
+func (g gaugeFunc) write(ctx context.Context, w io.Writer) {
+	samples, err := g.collect(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "metric collection failed", "metric", g.name, "error", err)
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, sample := range samples {
+		names := make([]string, 0, len(sample.Labels))
+		for name := range sample.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]string, 0, len(names))
+		for _, name := range names {
+			values = append(values, sample.Labels[name])
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelKey(names, values), formatFloat(sample.Value))
+	}
+}
+
+// Metrics собирает метрики HTTP API, бота и базы данных.
+type Metrics struct {
+	HTTPRequests      *counterVec
+	HTTPDuration      *histogramVec
+	BotUpdates        *counterVec
+	TelegramSendErrs  *counterVec
+	DBQueryDuration   *histogramVec
+	WebhookDeliveries *counterVec
+
+	mu     sync.Mutex
+	gauges []gaugeFunc
+}
+
+// NewMetrics создает набор метрик приложения.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		HTTPRequests:      newCounterVec("http_requests_total", "HTTP requests by route, method and status.", "route", "method", "status"),
+		HTTPDuration:      newHistogramVec("http_request_duration_seconds", "HTTP request latency by route and method.", defaultLatencyBuckets, "route", "method"),
+		BotUpdates:        newCounterVec("bot_updates_total", "Telegram updates by command and outcome.", "command", "outcome"),
+		TelegramSendErrs:  newCounterVec("telegram_send_errors_total", "Errors sending messages to Telegram."),
+		DBQueryDuration:   newHistogramVec("db_query_duration_seconds", "Database query latency by operation.", defaultLatencyBuckets, "operation"),
+		WebhookDeliveries: newCounterVec("webhook_deliveries_total", "Webhook delivery attempts by outcome.", "outcome"),
+	}
+}
+
+// appMetrics содержит метрики процесса.
+var appMetrics = NewMetrics()
+
+// RegisterGauge добавляет метрику, значения которой вычисляются при каждом сборе.
+func (m *Metrics) RegisterGauge(name, help string, collect func(ctx context.Context) ([]gaugeSample, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gaugeFunc{name: name, help: help, collect: collect})
+}
+
+// Expose выводит все метрики в текстовом формате Prometheus.
+func (m *Metrics) Expose(ctx context.Context, w io.Writer) {
+	m.HTTPRequests.write(w)
+	m.HTTPDuration.write(w)
+	m.BotUpdates.write(w)
+	m.TelegramSendErrs.write(w)
+	m.DBQueryDuration.write(w)
+	m.WebhookDeliveries.write(w)
+
+	m.mu.Lock()
+	gauges := append([]gaugeFunc(nil), m.gauges...)
+	m.mu.Unlock()
+	for _, gauge := range gauges {
+		gauge.write(ctx, w)
+	}
+}
+
+// Handler возвращает обработчик /metrics, требующий заголовок
+// Authorization: Bearer <token>, независимый от авторизации API.
+func (m *Metrics) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		provided, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			writeProblem(w, r, http.StatusUnauthorized, "unauthorized", "valid credentials are required")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), metricsScrapeTimeout)
+		defer cancel()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Expose(ctx, w)
+	})
+}
+
+// Middleware учитывает количество и длительность HTTP-запросов.
+// Маршрут берется из шаблона ServeMux, чтобы не плодить метки по идентификаторам.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.HTTPRequests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+		m.HTTPDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// botCommands перечисляет команды бота, которые учитываются в метриках отдельно.
+var botCommands = map[string]bool{
+	"/start": true, "/help": true, "/login": true, "/add": true, "/list": true,
+	"/note": true, "/path": true, "/graph": true, "/export": true, "/import": true, "/delete": true, "/clear": true,
+	"/link": true, "/link_edit": true, "/link_delete": true,
+}
+
+// ObserveBotUpdate учитывает обработанное обновление. Неизвестные команды
+// объединяются в одну метку, чтобы не плодить ряды.
+func (m *Metrics) ObserveBotUpdate(command, outcome string) {
+	if command != "text" && !botCommands[command] {
+		command = "unknown"
+	}
+	m.BotUpdates.Inc(command, outcome)
+}
+
+// registerStoreMetrics добавляет метрики пула соединений, длительности запросов
+// и бизнес-показатели хранилища.
+func (m *Metrics) registerStoreMetrics(store *NotesStore) error {
+	if err := registerQueryMetrics(store.db, m.DBQueryDuration); err != nil {
+		return err
+	}
+
+	m.RegisterGauge("db_pool_connections", "Database pool connections by state.", func(ctx context.Context) ([]gaugeSample, error) {
+		sqlDB, err := store.db.DB()
+		if err != nil {
+			return nil, err
+		}
+		stats := sqlDB.Stats()
+		return []gaugeSample{
+			{Labels: map[string]string{"state": "open"}, Value: float64(stats.OpenConnections)},
+			{Labels: map[string]string{"state": "in_use"}, Value: float64(stats.InUse)},
+			{Labels: map[string]string{"state": "idle"}, Value: float64(stats.Idle)},
+		}, nil
+	})
+	m.RegisterGauge("db_pool_wait_count", "Total number of connections waited for.", func(ctx context.Context) ([]gaugeSample, error) {
+		sqlDB, err := store.db.DB()
+		if err != nil {
+			return nil, err
+		}
+		return []gaugeSample{{Value: float64(sqlDB.Stats().WaitCount)}}, nil
+	})
+	m.RegisterGauge("db_pool_wait_seconds", "Total time spent waiting for connections.", func(ctx context.Context) ([]gaugeSample, error) {
+		sqlDB, err := store.db.DB()
+		if err != nil {
+			return nil, err
+		}
+		return []gaugeSample{{Value: sqlDB.Stats().WaitDuration.Seconds()}}, nil
+	})
+	m.RegisterGauge("notes_users_by_active_notes", "Users grouped by number of active notes.", func(ctx context.Context) ([]gaugeSample, error) {
+		buckets, err := store.UsersByActiveNotes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		samples := make([]gaugeSample, 0, len(activeNotesBuckets))
+		for _, bucket := range activeNotesBuckets {
+			samples = append(samples, gaugeSample{Labels: map[string]string{"bucket": bucket}, Value: float64(buckets[bucket])})
+		}
+		return samples, nil
+	})
+	return nil
+}
+
+// registerQueryMetrics измеряет длительность запросов GORM через колбэки.
+func registerQueryMetrics(db *gorm.DB, histogram *histogramVec) error {
+	const startKey = "metrics:start"
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(startKey, time.Now())
+	}
+	after := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			if value, ok := tx.InstanceGet(startKey); ok {
+				if start, ok := value.(time.Time); ok {
+					histogram.Observe(time.Since(start).Seconds(), operation)
+				}
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register("metrics:before_create", before),
+		callbacks.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		callbacks.Query().Before("gorm:query").Register("metrics:before_query", before),
+		callbacks.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		callbacks.Update().Before("gorm:update").Register("metrics:before_update", before),
+		callbacks.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		callbacks.Row().Before("gorm:row").Register("metrics:before_row", before),
+		callbacks.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	}
+	return errors.Join(errs...)
+}
+
+// labelKey формирует строку меток вида {a="1",b="2"}.
+func labelKey(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		parts[i] = fmt.Sprintf("%s=%q", name, value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withLabel добавляет метку к уже сформированной строке меток.
+func withLabel(key, name, value string) string {
+	label := fmt.Sprintf("%s=%q", name, value)
+	if key == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(key, "}") + "," + label + "}"
+}
+
+// sortedKeys возвращает ключи отображения в отсортированном порядке.
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFloat форматирует число для текстового формата Prometheus.
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
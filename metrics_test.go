@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics запрашивает /metrics у обработчика с заданным токеном.
+func scrapeMetrics(t *testing.T, handler http.Handler, token string) (int, string) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsHandlerRequiresToken(t *testing.T) {
+	handler := NewMetrics().Handler("secret")
+	for _, token := range []string{"", "wrong"} {
+		status, _ := scrapeMetrics(t, handler, token)
+		if status != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want %d", token, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestMetricsHandlerExposesSamples(t *testing.T) {
+	m := NewMetrics()
+	m.HTTPRequests.Inc("GET /v1/notes", "GET", "200")
+	m.HTTPRequests.Inc("GET /v1/notes", "GET", "200")
+	m.HTTPDuration.Observe(0.02, "GET /v1/notes", "GET")
+	m.ObserveBotUpdate("/add", "ok")
+	m.ObserveBotUpdate("/nonexistent", "ok")
+	m.RegisterGauge("notes_total", "Notes by status.", func(context.Context) ([]gaugeSample, error) {
+		return []gaugeSample{{Labels: map[string]string{"status": "active"}, Value: 3}}, nil
+	})
+
+	status, body := scrapeMetrics(t, m.Handler("secret"), "secret")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	for _, want := range []string{
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{route="GET /v1/notes",method="GET",status="200"} 2` + "\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{route="GET /v1/notes",method="GET",le="0.01"} 0` + "\n",
+		`http_request_duration_seconds_bucket{route="GET /v1/notes",method="GET",le="0.025"} 1` + "\n",
+		`http_request_duration_seconds_bucket{route="GET /v1/notes",method="GET",le="+Inf"} 1` + "\n",
+		`http_request_duration_seconds_count{route="GET /v1/notes",method="GET"} 1` + "\n",
+		`bot_updates_total{command="/add",outcome="ok"} 1` + "\n",
+		`bot_updates_total{command="unknown",outcome="ok"} 1` + "\n",
+		"telegram_send_errors_total 0\n",
+		"# TYPE notes_total gauge\n",
+		`notes_total{status="active"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsMiddlewareUsesRoutePattern(t *testing.T) {
+	m := NewMetrics()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/notes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+	for _, path := range []string{"/v1/notes/1", "/v1/notes/2", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	_, body := scrapeMetrics(t, m.Handler("secret"), "secret")
+	for _, want := range []string{
+		`http_requests_total{route="GET /v1/notes/{id}",method="GET",status="404"} 2` + "\n",
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition lacks %q:\n%s", want, body)
+		}
+	}
+}
@@ -1,38 +1,65 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// AuthMiddleware проверяет логин и пароль для HTTP API.
-type AuthMiddleware struct {
+// Credentials хранит пару логин и пароль.
+type Credentials struct {
 	User     string
 	Password string
 }
 
+// AuthMiddleware проверяет логин и пароль или токен доступа для HTTP API.
+// Учетные данные можно заменить на лету через SetCredentials.
+type AuthMiddleware struct {
+	credentials atomic.Pointer[Credentials]
+	// VerifyToken проверяет токен из заголовка Authorization: Bearer.
+	VerifyToken func(ctx context.Context, token string) (bool, error)
+}
+
+// NewAuthMiddleware создает проверку с заданными учетными данными.
+func NewAuthMiddleware(user, password string, verifyToken func(ctx context.Context, token string) (bool, error)) *AuthMiddleware {
+	a := &AuthMiddleware{VerifyToken: verifyToken}
+	a.SetCredentials(user, password)
+	return a
+}
+
+// SetCredentials атомарно заменяет логин и пароль.
+func (a *AuthMiddleware) SetCredentials(user, password string) {
+	a.credentials.Store(&Credentials{User: user, Password: password})
+}
+
 // Wrap добавляет Basic Auth проверку к обработчику.
-func (a AuthMiddleware) Wrap(next http.Handler) http.Handler {
+func (a *AuthMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !a.authorized(r) {
 			w.Header().Set("WWW-Authenticate", "Basic realm=notes")
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeProblem(w, r, http.StatusUnauthorized, "unauthorized", "valid credentials are required")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// authorized проверяет заголовок Authorization на соответствие логину и паролю.
-func (a AuthMiddleware) authorized(r *http.Request) bool {
-	if a.User == "" || a.Password == "" {
+// authorized проверяет заголовок Authorization на соответствие логину и паролю или токену.
+func (a *AuthMiddleware) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return a.tokenAuthorized(r.Context(), token)
+	}
+
+	creds := a.credentials.Load()
+	if creds.User == "" || creds.Password == "" {
 		return false
 	}
 	const prefix = "Basic "
-	header := r.Header.Get("Authorization")
 	if !strings.HasPrefix(header, prefix) {
 		return false
 	}
@@ -44,14 +71,125 @@ func (a AuthMiddleware) authorized(r *http.Request) bool {
 	if len(parts) != 2 {
 		return false
 	}
-	return parts[0] == a.User && parts[1] == a.Password
+	return parts[0] == creds.User && parts[1] == creds.Password
+}
+
+// tokenAuthorized проверяет токен доступа.
+func (a *AuthMiddleware) tokenAuthorized(ctx context.Context, token string) bool {
+	if a.VerifyToken == nil || token == "" {
+		return false
+	}
+	ok, err := a.VerifyToken(ctx, token)
+	if err != nil {
+		slog.ErrorContext(ctx, "token verification failed", "error", err)
+		return false
+	}
+	return ok
+}
+
+// BodyLimitMiddleware ограничивает размер тела запроса. Лимит можно менять на лету.
+type BodyLimitMiddleware struct {
+	limit atomic.Int64
+}
+
+// SetLimit задает максимальный размер тела запроса в байтах.
+func (m *BodyLimitMiddleware) SetLimit(limit int64) {
+	m.limit.Store(limit)
+}
+
+// Wrap добавляет ограничение размера тела к обработчику.
+func (m *BodyLimitMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := m.limit.Load(); limit > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxRequestIDLength ограничивает длину идентификатора, принятого от клиента.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware берет идентификатор запроса из X-Request-ID или генерирует новый,
+// кладет его в контекст и возвращает клиенту в том же заголовке.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID проверяет, что идентификатор от клиента безопасно писать в лог.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder запоминает код ответа и размер тела.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// WriteHeader запоминает код ответа.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write запоминает размер тела.
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.size += n
+	return n, err
 }
 
-// LoggingMiddleware выводит в лог информацию о запросе.
+// Flush передает буферизованные данные клиенту, если это поддерживается.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// LoggingMiddleware выводит в лог информацию о запросе: метод, путь, код ответа,
+// размер, пользователя и длительность.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("http %s %s %s", r.Method, r.URL.Path, time.Since(start))
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		slog.InfoContext(r.Context(), "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"size", recorder.size,
+			"user_id", r.URL.Query().Get("user_id"),
+			"duration", time.Since(start),
+		)
 	})
 }
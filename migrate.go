@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles содержит SQL-миграции схемы: NNNN_name.up.sql и NNNN_name.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID используется для pg_advisory_xact_lock, чтобы миграции
+// не выполнялись одновременно из нескольких процессов.
+const migrationLockID = 7268330142
+
+// migration описывает одну версию схемы.
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus описывает состояние одной миграции в базе данных.
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// schemaMigration хранит примененные версии схемы.
+type schemaMigration struct {
+	Version   int64     `gorm:"primaryKey"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName задает имя таблицы версий схемы.
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// loadMigrations читает встроенные миграции и сортирует их по версии.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+		versionPart, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", name)
+		}
+		body, err := fs.ReadFile(migrationFiles, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d has different names %q and %q", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// latestMigrationVersion возвращает версию схемы, которую знает текущая сборка.
+func latestMigrationVersion(migrations []migration) int64 {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable создает таблицу версий схемы, если ее еще нет.
+func (s *NotesStore) ensureMigrationsTable(ctx context.Context) error {
+	return s.db.WithContext(ctx).Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL
+	)`).Error
+}
+
+// appliedMigrations возвращает примененные версии схемы.
+func (s *NotesStore) appliedMigrations(db *gorm.DB) (map[int64]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := db.Order("version asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// SchemaVersion возвращает текущую версию схемы и последнюю версию, известную сборке.
+func (s *NotesStore) SchemaVersion(ctx context.Context) (current, latest int64, err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return 0, 0, err
+	}
+	err = s.db.WithContext(ctx).Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&current).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	return current, latestMigrationVersion(migrations), nil
+}
+
+// MigrationsCurrent проверяет, что версия схемы в базе совпадает с последней
+// версией, известной сборке. Таблица версий при этом не создается.
+func (s *NotesStore) MigrationsCurrent(ctx context.Context) (bool, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return false, err
+	}
+	var current int64
+	err = s.db.WithContext(ctx).Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&current).Error
+	if err != nil {
+		return false, err
+	}
+	return current == latestMigrationVersion(migrations), nil
+}
+
+// MigrateUp применяет все непримененные миграции и возвращает их количество.
+// Каждая миграция выполняется в отдельной транзакции вместе с записью версии.
+func (s *NotesStore) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		applied := false
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+			done, err := s.appliedMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := done[m.Version]; ok {
+				return nil
+			}
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			applied = true
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MigrateDown откатывает steps последних примененных миграций и возвращает их количество.
+func (s *NotesStore) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		reverted := false
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+			done, err := s.appliedMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := done[m.Version]; !ok {
+				return nil
+			}
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return err
+			}
+			reverted = true
+			return tx.Delete(&schemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if reverted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MigrationStatus возвращает список известных миграций с отметкой о применении.
+// Версии из базы, неизвестные сборке, тоже попадают в список.
+func (s *NotesStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		appliedAt := row.AppliedAt
+		statuses = append(statuses, MigrationStatus{Version: row.Version, Name: row.Name, AppliedAt: &appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS notes (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	text text NOT NULL,
+	status varchar(16) NOT NULL DEFAULT 'active',
+	created_at timestamptz,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_notes_user_id ON notes (user_id);
+CREATE INDEX IF NOT EXISTS idx_notes_status ON notes (status);
+
+CREATE TABLE IF NOT EXISTS note_links (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	from_id bigint NOT NULL,
+	to_id bigint NOT NULL,
+	created_at timestamptz,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_note_links_user_id ON note_links (user_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_from_id ON note_links (from_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_to_id ON note_links (to_id);
+
+CREATE TABLE IF NOT EXISTS authorized_users (
+	user_id bigint PRIMARY KEY
+);
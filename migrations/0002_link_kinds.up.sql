@@ -0,0 +1 @@
+ALTER TABLE note_links ADD COLUMN IF NOT EXISTS kind varchar(32) NOT NULL DEFAULT 'reference';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_note_links_unique;
+ALTER TABLE note_links DROP CONSTRAINT IF EXISTS fk_note_links_to;
+ALTER TABLE note_links DROP CONSTRAINT IF EXISTS fk_note_links_from;
@@ -0,0 +1,15 @@
+-- Перед применением исправьте существующие данные командой `check -repair`,
+-- иначе создание ограничений завершится ошибкой.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_note_links_from') THEN
+		ALTER TABLE note_links ADD CONSTRAINT fk_note_links_from
+			FOREIGN KEY (from_id) REFERENCES notes (id) ON UPDATE CASCADE ON DELETE CASCADE;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_note_links_to') THEN
+		ALTER TABLE note_links ADD CONSTRAINT fk_note_links_to
+			FOREIGN KEY (to_id) REFERENCES notes (id) ON UPDATE CASCADE ON DELETE CASCADE;
+	END IF;
+END
+$$;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_note_links_unique ON note_links (user_id, from_id, to_id, kind);
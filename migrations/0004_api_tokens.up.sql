@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id bigserial PRIMARY KEY,
+	name text NOT NULL,
+	token_hash varchar(64) NOT NULL,
+	created_at timestamptz,
+	revoked_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id bigint NOT NULL,
+	key varchar(255) NOT NULL,
+	request_hash varchar(64) NOT NULL,
+	status_code integer NOT NULL,
+	content_type text NOT NULL DEFAULT '',
+	response_body bytea NOT NULL,
+	created_at timestamptz NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+ALTER TABLE note_links ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
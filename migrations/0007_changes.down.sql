@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS note_links_record_update ON note_links;
+DROP TRIGGER IF EXISTS note_links_record_change ON note_links;
+DROP TRIGGER IF EXISTS notes_record_update ON notes;
+DROP TRIGGER IF EXISTS notes_record_change ON notes;
+DROP FUNCTION IF EXISTS record_change();
+DROP TABLE IF EXISTS changes;
+DROP SEQUENCE IF EXISTS change_seq;
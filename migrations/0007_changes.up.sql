@@ -0,0 +1,55 @@
+-- Журнал изменений для синхронизации: по одной строке на заметку или связь с номером
+-- последнего изменения. Номера выдаются под блокировкой пользователя, поэтому в пределах
+-- пользователя они фиксируются в том же порядке, в котором выданы.
+CREATE SEQUENCE IF NOT EXISTS change_seq;
+CREATE TABLE IF NOT EXISTS changes (
+	entity varchar(16) NOT NULL,
+	entity_id bigint NOT NULL,
+	user_id bigint NOT NULL,
+	seq bigint NOT NULL,
+	deleted boolean NOT NULL DEFAULT false,
+	PRIMARY KEY (entity, entity_id)
+);
+CREATE INDEX IF NOT EXISTS idx_changes_user_seq ON changes (user_id, seq);
+
+CREATE OR REPLACE FUNCTION record_change() RETURNS trigger AS $$
+DECLARE
+	row_user bigint;
+	row_id bigint;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		row_user := OLD.user_id;
+		row_id := OLD.id;
+	ELSE
+		row_user := NEW.user_id;
+		row_id := NEW.id;
+	END IF;
+	PERFORM pg_advisory_xact_lock(hashtext('changes'), hashtext(row_user::text));
+	INSERT INTO changes (entity, entity_id, user_id, seq, deleted)
+	VALUES (TG_ARGV[0], row_id, row_user, nextval('change_seq'), TG_OP = 'DELETE')
+	ON CONFLICT (entity, entity_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, seq = EXCLUDED.seq, deleted = EXCLUDED.deleted;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notes_record_change ON notes;
+CREATE TRIGGER notes_record_change AFTER INSERT OR DELETE ON notes
+	FOR EACH ROW EXECUTE FUNCTION record_change('note');
+DROP TRIGGER IF EXISTS notes_record_update ON notes;
+CREATE TRIGGER notes_record_update AFTER UPDATE ON notes
+	FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION record_change('note');
+DROP TRIGGER IF EXISTS note_links_record_change ON note_links;
+CREATE TRIGGER note_links_record_change AFTER INSERT OR DELETE ON note_links
+	FOR EACH ROW EXECUTE FUNCTION record_change('link');
+DROP TRIGGER IF EXISTS note_links_record_update ON note_links;
+CREATE TRIGGER note_links_record_update AFTER UPDATE ON note_links
+	FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION record_change('link');
+
+-- Существующие данные попадают в журнал, чтобы первая синхронизация вернула все.
+INSERT INTO changes (entity, entity_id, user_id, seq)
+SELECT 'note', id, user_id, nextval('change_seq') FROM notes ORDER BY id
+ON CONFLICT DO NOTHING;
+INSERT INTO changes (entity, entity_id, user_id, seq)
+SELECT 'link', id, user_id, nextval('change_seq') FROM note_links ORDER BY id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,20 @@
+CREATE OR REPLACE FUNCTION record_change() RETURNS trigger AS $$
+DECLARE
+	row_user bigint;
+	row_id bigint;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		row_user := OLD.user_id;
+		row_id := OLD.id;
+	ELSE
+		row_user := NEW.user_id;
+		row_id := NEW.id;
+	END IF;
+	PERFORM pg_advisory_xact_lock(hashtext('changes'), hashtext(row_user::text));
+	INSERT INTO changes (entity, entity_id, user_id, seq, deleted)
+	VALUES (TG_ARGV[0], row_id, row_user, nextval('change_seq'), TG_OP = 'DELETE')
+	ON CONFLICT (entity, entity_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, seq = EXCLUDED.seq, deleted = EXCLUDED.deleted;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,24 @@
+-- Триггер журнала изменений дополнительно уведомляет канал note_changes, чтобы реплики
+-- с EVENTS_LISTEN=true узнавали об изменениях, сделанных другими процессами.
+-- Уведомления доставляются после фиксации транзакции, одинаковые объединяются.
+CREATE OR REPLACE FUNCTION record_change() RETURNS trigger AS $$
+DECLARE
+	row_user bigint;
+	row_id bigint;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		row_user := OLD.user_id;
+		row_id := OLD.id;
+	ELSE
+		row_user := NEW.user_id;
+		row_id := NEW.id;
+	END IF;
+	PERFORM pg_advisory_xact_lock(hashtext('changes'), hashtext(row_user::text));
+	INSERT INTO changes (entity, entity_id, user_id, seq, deleted)
+	VALUES (TG_ARGV[0], row_id, row_user, nextval('change_seq'), TG_OP = 'DELETE')
+	ON CONFLICT (entity, entity_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, seq = EXCLUDED.seq, deleted = EXCLUDED.deleted;
+	PERFORM pg_notify('note_changes', row_user::text);
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
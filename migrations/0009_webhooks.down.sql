@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS note_links_enqueue_webhooks_update ON note_links;
+DROP TRIGGER IF EXISTS note_links_enqueue_webhooks ON note_links;
+DROP TRIGGER IF EXISTS notes_enqueue_webhooks_update ON notes;
+DROP TRIGGER IF EXISTS notes_enqueue_webhooks ON notes;
+DROP FUNCTION IF EXISTS enqueue_webhooks();
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
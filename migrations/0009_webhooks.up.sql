@@ -0,0 +1,88 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks (user_id);
+
+-- Исходящие уведомления (outbox). Строки добавляет триггер в той же транзакции,
+-- что и изменение, поэтому событие не теряется и не отправляется для отмененного изменения.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id bigint NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	user_id bigint NOT NULL,
+	event varchar(32) NOT NULL,
+	payload jsonb NOT NULL,
+	status varchar(16) NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamptz NOT NULL,
+	last_error text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL,
+	delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+	id bigserial PRIMARY KEY,
+	delivery_id bigint NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+	attempted_at timestamptz NOT NULL,
+	status_code integer NOT NULL DEFAULT 0,
+	error text NOT NULL DEFAULT '',
+	duration_ms bigint NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts (delivery_id);
+
+CREATE OR REPLACE FUNCTION enqueue_webhooks() RETURNS trigger AS $$
+DECLARE
+	row_data jsonb;
+	row_user bigint;
+	event_name text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		row_data := to_jsonb(OLD);
+		-- Заметка удаляется физически только после пометки deleted, о которой уже сообщено.
+		IF row_data->>'status' = 'deleted' THEN
+			RETURN NULL;
+		END IF;
+		event_name := TG_ARGV[0] || '.deleted';
+	ELSE
+		row_data := to_jsonb(NEW);
+		IF TG_OP = 'INSERT' THEN
+			event_name := TG_ARGV[0] || '.created';
+		ELSIF row_data->>'status' = 'deleted' AND to_jsonb(OLD)->>'status' = 'active' THEN
+			event_name := TG_ARGV[0] || '.deleted';
+		ELSIF row_data->>'status' = 'active' AND to_jsonb(OLD)->>'status' = 'deleted' THEN
+			event_name := TG_ARGV[0] || '.restored';
+		ELSE
+			event_name := TG_ARGV[0] || '.updated';
+		END IF;
+	END IF;
+	row_user := (row_data->>'user_id')::bigint;
+
+	INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, status, attempts, next_attempt_at, created_at)
+	SELECT w.id, row_user, event_name,
+		jsonb_build_object('event', event_name, 'user_id', row_user, 'occurred_at', now(), 'data', row_data),
+		'pending', 0, now(), now()
+	FROM webhooks w
+	WHERE w.user_id = row_user
+		AND (w.events = '' OR event_name = ANY (string_to_array(w.events, ',')));
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notes_enqueue_webhooks ON notes;
+CREATE TRIGGER notes_enqueue_webhooks AFTER INSERT OR DELETE ON notes
+	FOR EACH ROW EXECUTE FUNCTION enqueue_webhooks('note');
+DROP TRIGGER IF EXISTS notes_enqueue_webhooks_update ON notes;
+CREATE TRIGGER notes_enqueue_webhooks_update AFTER UPDATE ON notes
+	FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION enqueue_webhooks('note');
+DROP TRIGGER IF EXISTS note_links_enqueue_webhooks ON note_links;
+CREATE TRIGGER note_links_enqueue_webhooks AFTER INSERT OR DELETE ON note_links
+	FOR EACH ROW EXECUTE FUNCTION enqueue_webhooks('link');
+DROP TRIGGER IF EXISTS note_links_enqueue_webhooks_update ON note_links;
+CREATE TRIGGER note_links_enqueue_webhooks_update AFTER UPDATE ON note_links
+	FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION enqueue_webhooks('link');
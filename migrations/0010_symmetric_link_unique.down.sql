@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_note_links_symmetric_unique;
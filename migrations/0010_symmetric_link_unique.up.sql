@@ -0,0 +1,6 @@
+-- Симметричная связь и та же связь в обратном направлении — одна и та же связь.
+-- Индекс закрывает гонку параллельных вставок, которую проверка в приложении не ловит.
+-- Перед применением удалите такие дубликаты командой `check -repair`.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_note_links_symmetric_unique
+	ON note_links (user_id, LEAST(from_id, to_id), GREATEST(from_id, to_id), kind)
+	WHERE kind = 'related';
@@ -18,20 +18,86 @@ type Note struct {
 	UserID    int64      `gorm:"index;not null" json:"user_id"`
 	Text      string     `gorm:"type:text;not null" json:"text"`
 	Status    NoteStatus `gorm:"type:varchar(16);not null;default:'active';index" json:"status"`
+	Version   int64      `gorm:"not null;default:1" json:"version"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 }
 
+// LinkKind описывает вид связи между заметками.
+type LinkKind string
+
+const (
+	// LinkKindReference означает направленную ссылку одной заметки на другую.
+	LinkKindReference LinkKind = "reference"
+	// LinkKindRelated означает симметричную связь: она видна с обеих сторон.
+	LinkKindRelated LinkKind = "related"
+	// LinkKindDependsOn означает, что исходная заметка зависит от целевой.
+	LinkKindDependsOn LinkKind = "depends_on"
+)
+
+// Symmetric сообщает, отображается ли связь одинаково с обеих сторон.
+func (k LinkKind) Symmetric() bool {
+	return k == LinkKindRelated
+}
+
+// Valid проверяет, что вид связи известен.
+func (k LinkKind) Valid() bool {
+	switch k {
+	case LinkKindReference, LinkKindRelated, LinkKindDependsOn:
+		return true
+	}
+	return false
+}
+
+// symmetricLinkKinds возвращает список симметричных видов связей для запросов.
+func symmetricLinkKinds() []LinkKind {
+	return []LinkKind{LinkKindRelated}
+}
+
 // NoteLink описывает связь между двумя заметками одного пользователя.
+// Внешние ключи на заметки и уникальность (user_id, from_id, to_id, kind), а для
+// симметричных связей — и без учета направления, гарантируются на уровне базы данных,
+// см. migrations.
 type NoteLink struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    int64     `gorm:"index;not null" json:"user_id"`
-	FromID    uint      `gorm:"index;not null" json:"from_id"`
-	ToID      uint      `gorm:"index;not null" json:"to_id"`
+	UserID    int64     `gorm:"index;not null;uniqueIndex:idx_note_links_unique,priority:1" json:"user_id"`
+	FromID    uint      `gorm:"index;not null;uniqueIndex:idx_note_links_unique,priority:2" json:"from_id"`
+	ToID      uint      `gorm:"index;not null;uniqueIndex:idx_note_links_unique,priority:3" json:"to_id"`
+	Kind      LinkKind  `gorm:"type:varchar(32);not null;default:'reference';uniqueIndex:idx_note_links_unique,priority:4" json:"kind"`
+	Version   int64     `gorm:"not null;default:1" json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// OtherID возвращает идентификатор заметки на противоположном конце связи.
+func (l NoteLink) OtherID(noteID uint) uint {
+	if l.FromID == noteID {
+		return l.ToID
+	}
+	return l.FromID
+}
+
+// LinkDeletePolicy определяет, что происходит со связями при удалении заметки.
+type LinkDeletePolicy string
+
+const (
+	// LinkPolicyHide скрывает связи удаленных заметок, не удаляя их из базы.
+	LinkPolicyHide LinkDeletePolicy = "hide"
+	// LinkPolicyKeep оставляет связи удаленных заметок видимыми.
+	LinkPolicyKeep LinkDeletePolicy = "keep"
+	// LinkPolicyCascade физически удаляет связи вместе с пометкой заметки удаленной.
+	LinkPolicyCascade LinkDeletePolicy = "cascade"
+)
+
+// Valid проверяет, что политика известна.
+func (p LinkDeletePolicy) Valid() bool {
+	switch p {
+	case LinkPolicyHide, LinkPolicyKeep, LinkPolicyCascade:
+		return true
+	}
+	return false
+}
+
 // AuthorizedUser хранит авторизованных пользователей бота.
 type AuthorizedUser struct {
 	UserID int64 `gorm:"primaryKey" json:"user_id"`
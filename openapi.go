@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// openAPIDocument — спецификация HTTP API. Тела запросов проверяются по ее схемам,
+// поэтому документ и поведение API не расходятся.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiSpec — разобранная спецификация, загружается при старте.
+var apiSpec = mustLoadOpenAPISpec(openAPIDocument)
+
+// openAPISpec содержит части спецификации, нужные для проверки запросов и маршрутов.
+type openAPISpec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*jsonSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// jsonSchema описывает подмножество JSON Schema, используемое в спецификации.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Enum                 []any                  `json:"enum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+// mustLoadOpenAPISpec разбирает встроенную спецификацию. Ошибка означает поврежденную
+// сборку, поэтому приводит к панике при старте.
+func mustLoadOpenAPISpec(data []byte) *openAPISpec {
+	var spec openAPISpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	return &spec
+}
+
+// OpenAPIHandler отдает спецификацию API.
+func OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPIDocument)
+	})
+}
+
+// decodeJSON читает тело запроса, проверяет его по схеме из спецификации и разбирает в v.
+// Превышение MAX_BODY_BYTES возвращается как errPayloadTooLarge, остальные
+// проблемы — как invalid_payload со списком нарушений.
+func decodeJSON(r *http.Request, schema string, v any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errPayloadTooLarge
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return newValidation("invalid_payload", "request body is empty")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return newValidation("invalid_payload", "request body must be valid JSON: "+err.Error())
+	}
+	if problems := apiSpec.validate(schema, raw); len(problems) > 0 {
+		return newValidation("invalid_payload", strings.Join(problems, "; "))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return newValidation("invalid_payload", err.Error())
+	}
+	return nil
+}
+
+// validate проверяет значение по схеме из components.schemas и возвращает нарушения.
+func (s *openAPISpec) validate(name string, value any) []string {
+	schema, ok := s.Components.Schemas[name]
+	if !ok {
+		return []string{fmt.Sprintf("unknown schema %q", name)}
+	}
+	var problems []string
+	s.validateValue(schema, value, "", &problems)
+	return problems
+}
+
+// validateValue рекурсивно проверяет значение и дописывает нарушения в problems.
+func (s *openAPISpec) validateValue(schema *jsonSchema, value any, path string, problems *[]string) {
+	if schema.Ref != "" {
+		resolved, ok := s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: unknown schema %s", fieldName(path), schema.Ref))
+			return
+		}
+		schema = resolved
+	}
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, fieldName(path)+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				*problems = append(*problems, joinPath(path, key)+": is required")
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := schema.Properties[key]; ok {
+				s.validateValue(property, object[key], joinPath(path, key), problems)
+				continue
+			}
+			if string(schema.AdditionalProperties) == "false" {
+				*problems = append(*problems, joinPath(path, key)+": unknown field")
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			fail("must contain at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			fail("must contain at most %d items", *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				s.validateValue(schema.Items, item, path+"["+strconv.Itoa(i)+"]", problems)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		length := utf8.RuneCountInString(text)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("must be at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must be at most %d characters", *schema.MaxLength)
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		parsed, err := number.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if schema.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if schema.Minimum != nil && parsed < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && parsed > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+			return
+		}
+	}
+
+	if len(schema.Enum) > 0 {
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return
+			}
+		}
+		values := make([]string, 0, len(schema.Enum))
+		for _, allowed := range schema.Enum {
+			values = append(values, fmt.Sprint(allowed))
+		}
+		fail("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+// joinPath добавляет имя поля к пути вложенного значения.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// fieldName возвращает путь к полю для сообщения об ошибке.
+func fieldName(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
+
+// specPathParam находит параметры пути вида {id} в шаблоне из спецификации.
+var specPathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// contractMethods перечисляет HTTP-методы, которые могут встречаться в paths.
+var contractMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// CheckContract сверяет маршруты API со спецификацией в обе стороны: каждая операция
+// из таблицы routes должна быть описана, а каждая описанная операция — обслуживаться
+// обработчиком, то есть запрос не должен заканчиваться not_found или method_not_allowed.
+// Запросы отправляются без user_id, поэтому обработчики отвечают до обращения к базе.
+func CheckContract(api *API, user, password string) []string {
+	var problems []string
+	for _, route := range api.routes() {
+		if _, ok := apiSpec.Paths[route.Path][strings.ToLower(route.Method)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s %s: route is not described in the specification", route.Method, route.Path))
+		}
+	}
+
+	paths := make([]string, 0, len(apiSpec.Paths))
+	for path := range apiSpec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	handler := api.Handler()
+	for _, path := range paths {
+		for _, method := range contractMethods {
+			if _, ok := apiSpec.Paths[path][method]; !ok {
+				continue
+			}
+			method = strings.ToUpper(method)
+			target := specPathParam.ReplaceAllString(path, "1")
+			req := httptest.NewRequest(method, target, strings.NewReader("{}"))
+			req.SetBasicAuth(user, password)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			var problem Problem
+			_ = json.Unmarshal(recorder.Body.Bytes(), &problem)
+			switch {
+			case recorder.Code == http.StatusMethodNotAllowed:
+				problems = append(problems, fmt.Sprintf("%s %s: method is not handled", method, path))
+			case recorder.Code == http.StatusNotFound && problem.Code == "not_found":
+				problems = append(problems, fmt.Sprintf("%s %s: route is not registered", method, path))
+			case recorder.Code == http.StatusUnauthorized:
+				problems = append(problems, fmt.Sprintf("%s %s: rejected credentials", method, path))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Problem описывает ответ об ошибке в формате RFC 7807 (application/problem+json).
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errPayloadTooLarge возвращается, если тело запроса превышает MAX_BODY_BYTES.
+var errPayloadTooLarge = newValidation("payload_too_large", "request body is too large")
+
+// statusForError сопоставляет виду ошибки HTTP-статус. Это единственное место,
+// где ошибки предметной области превращаются в коды ответа.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, errPayloadTooLarge), errors.Is(err, errImportTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errPreconditionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError отправляет ошибку как problem+json. Внутренние ошибки пишутся в лог,
+// а клиент получает только общий текст.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var domain *DomainError
+	if errors.As(err, &domain) {
+		writeProblem(w, r, statusForError(err), domain.Code, domain.Message)
+		return
+	}
+	slog.ErrorContext(r.Context(), "request failed", "error", err)
+	writeProblem(w, r, http.StatusInternalServerError, "internal", "internal server error")
+}
+
+// writeProblem отправляет ответ об ошибке с заданным статусом и кодом.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		Instance:  r.URL.Path,
+		RequestID: requestIDFromContext(r.Context()),
+	})
+}
+
+// writeMethodNotAllowed отвечает 405 для неподдерживаемого метода.
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method "+r.Method+" is not allowed")
+}
+
+// writeNotFound отвечает 404 для неизвестного пути.
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "not_found", "no route for "+r.URL.Path)
+}
+
+// invalidParameter возвращает ошибку проверки для параметра запроса.
+func invalidParameter(name string) error {
+	return newValidation("invalid_parameter", "invalid "+name)
+}
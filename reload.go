@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// logLevel задает текущий уровень логирования и меняется при перезагрузке конфигурации.
+var logLevel = new(slog.LevelVar)
+
+// configField описывает параметр конфигурации для сравнения при перезагрузке.
+type configField struct {
+	name       string
+	secret     bool
+	reloadable bool
+	value      func(Config) string
+}
+
+// configFields перечисляет параметры, которые сравниваются при перезагрузке.
+// Параметры без reloadable требуют перезапуска и при перезагрузке не меняются.
+var configFields = []configField{
+	{name: "DATABASE_URL", secret: true, value: func(c Config) string { return c.DatabaseURL }},
+	{name: "HTTP_ADDR", value: func(c Config) string { return c.HTTPAddr }},
+	{name: "MODE", value: func(c Config) string { return string(c.Mode) }},
+	{name: "BOT_TOKEN", secret: true, value: func(c Config) string { return c.BotToken }},
+	{name: "LINK_DELETE_POLICY", value: func(c Config) string { return string(c.LinkPolicy) }},
+	{name: "HTTP_READ_TIMEOUT", value: func(c Config) string { return c.HTTPReadTimeout.String() }},
+	{name: "HTTP_WRITE_TIMEOUT", value: func(c Config) string { return c.HTTPWriteTimeout.String() }},
+	{name: "SHUTDOWN_TIMEOUT", value: func(c Config) string { return c.ShutdownTimeout.String() }},
+	{name: "DRAIN_DELAY", value: func(c Config) string { return c.DrainDelay.String() }},
+	{name: "BOT_POLL_TIMEOUT", value: func(c Config) string { return fmt.Sprint(c.BotPollTimeout) }},
+	{name: "BOT_DEBUG", value: func(c Config) string { return fmt.Sprint(c.BotDebug) }},
+	{name: "BOT_WORKERS", value: func(c Config) string { return fmt.Sprint(c.BotWorkers) }},
+	{name: "LOG_FORMAT", value: func(c Config) string { return string(c.LogFormat) }},
+	{name: "METRICS_TOKEN", secret: true, value: func(c Config) string { return c.MetricsToken }},
+	{name: "TRACE_EXPORTER", value: func(c Config) string { return string(c.TraceExporter) }},
+	{name: "TRACE_OTLP_ENDPOINT", value: func(c Config) string { return c.TraceEndpoint }},
+	{name: "TRACE_SERVICE_NAME", value: func(c Config) string { return c.TraceServiceName }},
+	{name: "TRACE_SAMPLE_RATIO", value: func(c Config) string { return fmt.Sprint(c.TraceSampleRatio) }},
+	{name: "EVENTS_HEARTBEAT", value: func(c Config) string { return c.EventsHeartbeat.String() }},
+	{name: "EVENTS_LISTEN", value: func(c Config) string { return fmt.Sprint(c.EventsListen) }},
+	{name: "WEBHOOK_POLL_INTERVAL", value: func(c Config) string { return c.WebhookPollInterval.String() }},
+	{name: "WEBHOOK_TIMEOUT", value: func(c Config) string { return c.WebhookTimeout.String() }},
+	{name: "WEBHOOK_BACKOFF", value: func(c Config) string { return c.WebhookBackoff.String() }},
+	{name: "WEBHOOK_MAX_ATTEMPTS", value: func(c Config) string { return fmt.Sprint(c.WebhookMaxAttempts) }},
+	{name: "API_USER", reloadable: true, value: func(c Config) string { return c.APIUser }},
+	{name: "API_PASSWORD", secret: true, reloadable: true, value: func(c Config) string { return c.APIPassword }},
+	{name: "BOT_LOGIN", reloadable: true, value: func(c Config) string { return c.BotLogin }},
+	{name: "BOT_PASSWORD", secret: true, reloadable: true, value: func(c Config) string { return c.BotPassword }},
+	{name: "LOG_LEVEL", reloadable: true, value: func(c Config) string { return c.LogLevel.String() }},
+	{name: "MAX_BODY_BYTES", reloadable: true, value: func(c Config) string { return fmt.Sprint(c.MaxBodyBytes) }},
+	{name: "IDEMPOTENCY_TTL", reloadable: true, value: func(c Config) string { return c.IdempotencyTTL.String() }},
+}
+
+// configChanges сравнивает конфигурации и возвращает описания изменений,
+// разделенные на применимые на лету и требующие перезапуска. Значения секретов не выводятся.
+func configChanges(old, updated Config) (applied, ignored []string) {
+	for _, field := range configFields {
+		before, after := field.value(old), field.value(updated)
+		if before == after {
+			continue
+		}
+		change := fmt.Sprintf("%s: %q -> %q", field.name, before, after)
+		if field.secret {
+			change = field.name + " changed"
+		}
+		if field.reloadable {
+			applied = append(applied, change)
+		} else {
+			ignored = append(ignored, change)
+		}
+	}
+	return applied, ignored
+}
+
+// mergeReloadable переносит в текущую конфигурацию только параметры, меняющиеся на лету.
+func mergeReloadable(current, updated Config) Config {
+	current.APIUser = updated.APIUser
+	current.APIPassword = updated.APIPassword
+	current.BotLogin = updated.BotLogin
+	current.BotPassword = updated.BotPassword
+	current.LogLevel = updated.LogLevel
+	current.MaxBodyBytes = updated.MaxBodyBytes
+	current.IdempotencyTTL = updated.IdempotencyTTL
+	return current
+}
+
+// watchReload перечитывает конфигурацию по SIGHUP и передает новые значения в apply.
+// Некорректная конфигурация отклоняется, а сервисы продолжают работать со старой.
+func watchReload(ctx context.Context, current Config, reload func() (Config, error), apply func(Config)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		slog.Info("reloading configuration")
+		updated, err := reload()
+		if err == nil {
+			err = updated.Validate()
+		}
+		if err != nil {
+			slog.Error("configuration reload rejected", "error", err)
+			continue
+		}
+
+		applied, ignored := configChanges(current, updated)
+		for _, change := range ignored {
+			slog.Warn("configuration change requires restart, ignored", "change", change)
+		}
+		if len(applied) == 0 {
+			slog.Info("configuration reloaded, nothing to apply")
+			continue
+		}
+
+		current = mergeReloadable(current, updated)
+		apply(current)
+		slog.Info("configuration reloaded", "changes", strings.Join(applied, "; "))
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ServeMode определяет, какие компоненты запускает процесс.
+type ServeMode string
+
+const (
+	// ServeModeAll запускает HTTP API и Telegram-бота.
+	ServeModeAll ServeMode = "all"
+	// ServeModeAPI запускает только HTTP API.
+	ServeModeAPI ServeMode = "api"
+	// ServeModeBot запускает только Telegram-бота.
+	ServeModeBot ServeMode = "bot"
+)
+
+// Valid проверяет, что режим известен.
+func (m ServeMode) Valid() bool {
+	switch m {
+	case ServeModeAll, ServeModeAPI, ServeModeBot:
+		return true
+	}
+	return false
+}
+
+// service описывает компонент приложения с собственным жизненным циклом.
+type service interface {
+	// Name возвращает имя компонента для логов.
+	Name() string
+	// Run блокируется до отмены ctx или ошибки и корректно останавливает компонент.
+	Run(ctx context.Context) error
+	// Ready сообщает, готов ли компонент обслуживать запросы.
+	Ready() bool
+}
+
+// apiService запускает HTTP-сервер API.
+type apiService struct {
+	server          *http.Server
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	health          *Health
+	ready           atomic.Bool
+}
+
+// newAPIService создает компонент HTTP API с адресом и тайм-аутами из конфигурации.
+// Перед остановкой готовность в health переводится в отказ на время drainDelay.
+func newAPIService(config Config, handler http.Handler, health *Health) *apiService {
+	return &apiService{
+		server: &http.Server{
+			Addr:         config.HTTPAddr,
+			Handler:      handler,
+			ReadTimeout:  config.HTTPReadTimeout,
+			WriteTimeout: config.HTTPWriteTimeout,
+		},
+		shutdownTimeout: config.ShutdownTimeout,
+		drainDelay:      config.DrainDelay,
+		health:          health,
+	}
+}
+
+// Name возвращает имя компонента.
+func (s *apiService) Name() string {
+	return "http"
+}
+
+// Ready сообщает, принимает ли сервер соединения.
+func (s *apiService) Ready() bool {
+	return s.ready.Load()
+}
+
+// Run слушает адрес и обслуживает запросы до отмены ctx.
+func (s *apiService) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("HTTP server started", "addr", listener.Addr().String())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.server.Serve(listener)
+	}()
+	s.ready.Store(true)
+	defer s.ready.Store(false)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.ready.Store(false)
+	if s.health != nil && s.drainDelay > 0 {
+		s.health.StartDraining()
+		slog.Info("readiness set to failing, draining traffic", "delay", s.drainDelay)
+		select {
+		case <-time.After(s.drainDelay):
+		case err := <-errs:
+			return err
+		}
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		// Незавершенные запросы обрываются, чтобы не обращаться к закрытой базе.
+		if closeErr := s.server.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		return fmt.Errorf("http shutdown: %w", err)
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// botService запускает Telegram-бота.
+type botService struct {
+	bot *TelegramBot
+}
+
+// Name возвращает имя компонента.
+func (s botService) Name() string {
+	return "bot"
+}
+
+// Ready сообщает, получает ли бот обновления.
+func (s botService) Ready() bool {
+	return s.bot.Ready()
+}
+
+// Run получает обновления до отмены ctx.
+func (s botService) Run(ctx context.Context) error {
+	return s.bot.Start(ctx)
+}
+
+// runServices запускает компоненты и возвращается, когда остановлены все. Ошибка любого
+// компонента, например занятый адрес HTTP, отменяет общий контекст: остальные компоненты
+// останавливаются, а процесс завершается с ошибкой, а не продолжает работать без части функций.
+func runServices(ctx context.Context, services []service) error {
+	if len(services) == 0 {
+		return errors.New("nothing to run")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, svc := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := svc.Run(ctx)
+			if err != nil {
+				slog.Error("service stopped with error", "service", svc.Name(), "error", err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", svc.Name(), err))
+				mu.Unlock()
+				cancel()
+				return
+			}
+			slog.Info("service stopped", "service", svc.Name())
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// shutdownHook описывает действие, выполняемое после остановки компонентов.
+type shutdownHook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// lifecycle выполняет действия остановки в порядке, обратном регистрации:
+// ресурсы, открытые первыми, например база данных, закрываются последними.
+type lifecycle struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+// OnStop регистрирует действие остановки.
+func (l *lifecycle) OnStop(name string, stop func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, stop: stop})
+}
+
+// Stop выполняет все действия, даже если часть из них завершилась ошибкой.
+// Срок ctx общий для всех действий.
+func (l *lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := l.hooks
+	l.hooks = nil
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		start := time.Now()
+		if err := hook.stop(ctx); err != nil {
+			slog.Error("shutdown step failed", "step", hook.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", hook.name, err))
+			continue
+		}
+		slog.Info("shutdown step done", "step", hook.name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// notifyShutdown возвращает контекст, отменяемый первым SIGINT или SIGTERM.
+// Повторный сигнал завершает процесс немедленно, не дожидаясь остановки компонентов.
+func notifyShutdown(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Info("shutdown requested, send the signal again to force exit", "signal", sig.String())
+			cancel()
+		case <-parent.Done():
+			signal.Stop(signals)
+			return
+		}
+		sig := <-signals
+		slog.Error("forced exit", "signal", sig.String())
+		os.Exit(1)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -11,25 +14,108 @@ import (
 
 // NotesStore управляет хранением заметок в PostgreSQL через GORM.
 type NotesStore struct {
-	db *gorm.DB
+	db         *gorm.DB
+	linkPolicy LinkDeletePolicy
+	// onChange вызывается после успешного изменения заметок или связей пользователя.
+	onChange func(userID int64)
 }
 
 // NewNotesStore создает подключение к базе данных и выполняет миграции.
-func NewNotesStore(databaseURL string) (*NotesStore, error) {
+func NewNotesStore(databaseURL string, linkPolicy LinkDeletePolicy) (*NotesStore, error) {
+	store, err := OpenNotesStore(databaseURL, linkPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Migrate(context.Background()); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// OpenNotesStore создает подключение к базе данных без выполнения миграций.
+func OpenNotesStore(databaseURL string, linkPolicy LinkDeletePolicy) (*NotesStore, error) {
 	if databaseURL == "" {
 		return nil, errors.New("DATABASE_URL is not set")
 	}
+	if linkPolicy == "" {
+		linkPolicy = LinkPolicyHide
+	}
+	if !linkPolicy.Valid() {
+		return nil, fmt.Errorf("unknown link delete policy %q", linkPolicy)
+	}
 
-	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{Logger: newGormLogger(), TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.WithContext(context.Background()).AutoMigrate(&Note{}, &NoteLink{}, &AuthorizedUser{}); err != nil {
-		return nil, err
+	return &NotesStore{db: db, linkPolicy: linkPolicy}, nil
+}
+
+// OnChange задает функцию, которую хранилище вызывает после каждого успешного
+// изменения заметок или связей пользователя. Вызывается после фиксации транзакции.
+func (s *NotesStore) OnChange(fn func(userID int64)) {
+	s.onChange = fn
+}
+
+// changed сообщает об изменении данных пользователя.
+func (s *NotesStore) changed(userID int64) {
+	if s.onChange != nil {
+		s.onChange(userID)
+	}
+}
+
+// lockUserChanges берет advisory-блокировку журнала изменений пользователя, ту же, что
+// триггер record_change из migrations/0007_changes.up.sql. Повторный вызов в той же
+// транзакции не ждет.
+func lockUserChanges(tx *gorm.DB, userID int64) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext('changes'), hashtext(?))", strconv.FormatInt(userID, 10)).Error
+}
+
+// writeTx выполняет fn в транзакции, которая до первой записи берет блокировку журнала
+// изменений пользователя. Триггер record_change берет ее уже после блокировок строк,
+// поэтому запись без предварительной блокировки могла бы держать строку, ожидая
+// журнал, пока другая транзакция держит журнал, ожидая ту же строку. Все изменения
+// заметок и связей идут через writeTx.
+func (s *NotesStore) writeTx(ctx context.Context, userID int64, fn func(tx *gorm.DB) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockUserChanges(tx, userID); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// Ping проверяет доступность базы данных.
+func (s *NotesStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.PingContext(ctx)
+}
 
-	return &NotesStore{db: db}, nil
+// Migrate применяет непримененные миграции. Если схема в базе новее,
+// чем известно текущей сборке, работа с ней запрещается.
+func (s *NotesStore) Migrate(ctx context.Context) error {
+	current, latest, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: database is at %d, binary supports %d", errSchemaTooNew, current, latest)
+	}
+	applied, err := s.MigrateUp(ctx)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		slog.InfoContext(ctx, "migrations applied", "count", applied)
+	}
+	return nil
 }
 
 // Close закрывает соединение с базой данных.
@@ -43,10 +129,14 @@ func (s *NotesStore) Close() error {
 
 // AddNote сохраняет новую активную заметку пользователя.
 func (s *NotesStore) AddNote(ctx context.Context, userID int64, text string) (Note, error) {
-	note := Note{UserID: userID, Text: text, Status: NoteStatusActive}
-	if err := s.db.WithContext(ctx).Create(&note).Error; err != nil {
+	note := Note{UserID: userID, Text: text, Status: NoteStatusActive, Version: 1}
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		return tx.Create(&note).Error
+	})
+	if err != nil {
 		return Note{}, err
 	}
+	s.changed(userID)
 	return note, nil
 }
 
@@ -63,30 +153,121 @@ func (s *NotesStore) ListNotes(ctx context.Context, userID int64) ([]Note, error
 	return notes, nil
 }
 
-// DeleteNote не удаляет запись физически, а меняет статус на deleted.
-func (s *NotesStore) DeleteNote(ctx context.Context, userID int64, id int) (bool, error) {
-	result := s.db.WithContext(ctx).
-		Model(&Note{}).
+// GetNote возвращает активную заметку пользователя по идентификатору.
+func (s *NotesStore) GetNote(ctx context.Context, userID int64, id int) (Note, bool, error) {
+	var note Note
+	err := s.db.WithContext(ctx).
 		Where("user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusActive).
-		Update("status", NoteStatusDeleted)
-	if result.Error != nil {
-		return false, result.Error
+		First(&note).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Note{}, false, nil
+		}
+		return Note{}, false, err
 	}
-	return result.RowsAffected > 0, nil
+	return note, true, nil
+}
+
+// UpdateNote заменяет текст активной заметки пользователя и возвращает обновленную заметку.
+// Если version не равен anyVersion, текст меняется только при совпадении версии,
+// иначе возвращается errVersionMismatch.
+func (s *NotesStore) UpdateNote(ctx context.Context, userID int64, id int, text string, version int64) (Note, bool, error) {
+	var updated bool
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		res := whereVersion(tx.Model(&Note{}), version).
+			Where("user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusActive).
+			Updates(map[string]any{"text": text, "version": gorm.Expr("version + 1")})
+		if res.Error != nil {
+			return res.Error
+		}
+		updated = res.RowsAffected > 0
+		if updated || version == anyVersion {
+			return nil
+		}
+		found, err := rowExists(tx.Model(&Note{}), "user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusActive)
+		if err != nil || !found {
+			return err
+		}
+		return errVersionMismatch
+	})
+	if err != nil || !updated {
+		return Note{}, false, err
+	}
+	s.changed(userID)
+	return s.GetNote(ctx, userID, id)
+}
+
+// ListNotesByIDs возвращает активные заметки пользователя с заданными идентификаторами.
+func (s *NotesStore) ListNotesByIDs(ctx context.Context, userID int64, ids []uint) ([]Note, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var notes []Note
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND status = ? AND id IN ?", userID, NoteStatusActive, ids).
+		Order("id asc").
+		Find(&notes).Error
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// DeleteNote не удаляет запись физически, а меняет статус на deleted.
+// При политике cascade связи заметки удаляются в той же транзакции.
+// Версия проверяется так же, как в UpdateNote.
+func (s *NotesStore) DeleteNote(ctx context.Context, userID int64, id int, version int64) (bool, error) {
+	var deleted bool
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		result := whereVersion(tx.Model(&Note{}), version).
+			Where("user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusActive).
+			Updates(map[string]any{"status": NoteStatusDeleted, "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected > 0
+		if !deleted && version != anyVersion {
+			found, err := rowExists(tx.Model(&Note{}), "user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusActive)
+			if err != nil {
+				return err
+			}
+			if found {
+				return errVersionMismatch
+			}
+		}
+		if !deleted || s.linkPolicy != LinkPolicyCascade {
+			return nil
+		}
+		return tx.Where("user_id = ? AND (from_id = ? OR to_id = ?)", userID, id, id).Delete(&NoteLink{}).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		s.changed(userID)
+	}
+	return deleted, nil
 }
 
 // ClearNotes помечает все активные заметки пользователя как удаленные.
+// При политике cascade связи пользователя удаляются в той же транзакции.
 func (s *NotesStore) ClearNotes(ctx context.Context, userID int64) error {
-	return s.db.WithContext(ctx).
-		Model(&Note{}).
-		Where("user_id = ? AND status = ?", userID, NoteStatusActive).
-		Update("status", NoteStatusDeleted).Error
+	_, err := s.DeleteNotes(ctx, userID, NoteFilter{}, false)
+	return err
 }
 
-// AddLink создает связь между активными заметками пользователя.
-func (s *NotesStore) AddLink(ctx context.Context, userID int64, fromID, toID int) (NoteLink, error) {
+// AddLink создает связь заданного вида между активными заметками пользователя.
+// Пустой вид означает обычную направленную ссылку. Ошибки проверки возвращаются
+// как errSelfLink, errUnknownLinkKind, errNoteNotFound и errDuplicateLink.
+func (s *NotesStore) AddLink(ctx context.Context, userID int64, fromID, toID int, kind LinkKind) (NoteLink, error) {
 	if fromID == toID {
-		return NoteLink{}, fmt.Errorf("from_id and to_id must be different")
+		return NoteLink{}, errSelfLink
+	}
+	if kind == "" {
+		kind = LinkKindReference
+	}
+	if !kind.Valid() {
+		return NoteLink{}, errUnknownLinkKind
 	}
 
 	exists, err := s.notesExist(ctx, userID, uint(fromID), uint(toID))
@@ -94,56 +275,119 @@ func (s *NotesStore) AddLink(ctx context.Context, userID int64, fromID, toID int
 		return NoteLink{}, err
 	}
 	if !exists {
-		return NoteLink{}, fmt.Errorf("notes not found or deleted")
+		return NoteLink{}, errNoteNotFound
 	}
 
-	link := NoteLink{UserID: userID, FromID: uint(fromID), ToID: uint(toID)}
-	if err := s.db.WithContext(ctx).Create(&link).Error; err != nil {
+	duplicate, err := s.linkExists(ctx, userID, 0, uint(fromID), uint(toID), kind)
+	if err != nil {
 		return NoteLink{}, err
 	}
+	if duplicate {
+		return NoteLink{}, errDuplicateLink
+	}
+
+	link := NoteLink{UserID: userID, FromID: uint(fromID), ToID: uint(toID), Kind: kind, Version: 1}
+	err = s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		return tx.Create(&link).Error
+	})
+	if err != nil {
+		return NoteLink{}, translateLinkError(err)
+	}
+	s.changed(userID)
 	return link, nil
 }
 
-// UpdateLink изменяет целевую заметку у связи.
-func (s *NotesStore) UpdateLink(ctx context.Context, userID int64, linkID uint, toID uint) (bool, error) {
+// UpdateLink изменяет целевую заметку у связи и возвращает обновленную связь.
+// Для чужой или несуществующей связи возвращается false без ошибки. Если version
+// не равен anyVersion, связь меняется только при совпадении версии, иначе
+// возвращается errVersionMismatch.
+func (s *NotesStore) UpdateLink(ctx context.Context, userID int64, linkID uint, toID uint, version int64) (NoteLink, bool, error) {
 	var existing NoteLink
 	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", linkID, userID).First(&existing).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, nil
+			return NoteLink{}, false, nil
 		}
-		return false, err
+		return NoteLink{}, false, err
+	}
+	if version != anyVersion && existing.Version != version {
+		return NoteLink{}, false, errVersionMismatch
 	}
 
+	if existing.FromID == toID {
+		return NoteLink{}, false, errSelfLink
+	}
 	exists, err := s.notesExist(ctx, userID, existing.FromID, toID)
 	if err != nil {
-		return false, err
+		return NoteLink{}, false, err
 	}
 	if !exists {
-		return false, fmt.Errorf("notes not found or deleted")
+		return NoteLink{}, false, errNoteNotFound
 	}
 
-	if err := s.db.WithContext(ctx).Model(&NoteLink{}).
-		Where("id = ? AND user_id = ?", linkID, userID).
-		Update("to_id", toID).Error; err != nil {
-		return false, err
+	duplicate, err := s.linkExists(ctx, userID, linkID, existing.FromID, toID, existing.Kind)
+	if err != nil {
+		return NoteLink{}, false, err
+	}
+	if duplicate {
+		return NoteLink{}, false, errDuplicateLink
 	}
 
-	return true, nil
+	// Версия проверяется повторно в самом UPDATE: связь могли изменить после чтения.
+	var res *gorm.DB
+	err = s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		res = whereVersion(tx.Model(&NoteLink{}), version).
+			Where("id = ? AND user_id = ?", linkID, userID).
+			Updates(map[string]any{"to_id": toID, "version": gorm.Expr("version + 1")})
+		return res.Error
+	})
+	if err != nil {
+		return NoteLink{}, false, translateLinkError(err)
+	}
+	if res.RowsAffected == 0 {
+		if version == anyVersion {
+			return NoteLink{}, false, nil
+		}
+		return NoteLink{}, false, errVersionMismatch
+	}
+	s.changed(userID)
+	var updated NoteLink
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", linkID, userID).First(&updated).Error; err != nil {
+		return NoteLink{}, false, err
+	}
+	return updated, true, nil
 }
 
-// DeleteLink удаляет связь между заметками.
-func (s *NotesStore) DeleteLink(ctx context.Context, userID int64, linkID uint) (bool, error) {
-	res := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", linkID, userID).Delete(&NoteLink{})
-	if res.Error != nil {
-		return false, res.Error
+// DeleteLink удаляет связь между заметками. Версия проверяется так же, как в UpdateLink.
+func (s *NotesStore) DeleteLink(ctx context.Context, userID int64, linkID uint, version int64) (bool, error) {
+	var deleted bool
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		res := whereVersion(tx, version).Where("id = ? AND user_id = ?", linkID, userID).Delete(&NoteLink{})
+		if res.Error != nil {
+			return res.Error
+		}
+		deleted = res.RowsAffected > 0
+		if deleted || version == anyVersion {
+			return nil
+		}
+		found, err := rowExists(tx.Model(&NoteLink{}), "id = ? AND user_id = ?", linkID, userID)
+		if err != nil || !found {
+			return err
+		}
+		return errVersionMismatch
+	})
+	if err != nil {
+		return false, err
 	}
-	return res.RowsAffected > 0, nil
+	if deleted {
+		s.changed(userID)
+	}
+	return deleted, nil
 }
 
 // ListLinks возвращает список связей заметок пользователя.
 func (s *NotesStore) ListLinks(ctx context.Context, userID int64) ([]NoteLink, error) {
 	var links []NoteLink
-	err := s.db.WithContext(ctx).
+	err := s.visibleLinks(s.db.WithContext(ctx)).
 		Where("user_id = ?", userID).
 		Order("from_id asc, to_id asc").
 		Find(&links).Error
@@ -153,12 +397,28 @@ func (s *NotesStore) ListLinks(ctx context.Context, userID int64) ([]NoteLink, e
 	return links, nil
 }
 
-// ListLinksForNote возвращает связи для конкретной заметки пользователя.
+// ListLinksForNote возвращает исходящие связи заметки пользователя.
+// Симметричные связи, направленные в заметку, тоже считаются исходящими.
 func (s *NotesStore) ListLinksForNote(ctx context.Context, userID int64, fromID int) ([]NoteLink, error) {
 	var links []NoteLink
-	err := s.db.WithContext(ctx).
-		Where("user_id = ? AND from_id = ?", userID, fromID).
-		Order("to_id asc").
+	err := s.visibleLinks(s.db.WithContext(ctx)).
+		Where("user_id = ? AND (from_id = ? OR (to_id = ? AND kind IN ?))", userID, fromID, fromID, symmetricLinkKinds()).
+		// Связи упорядочены по номеру второй заметки, как и до появления симметричных связей.
+		Order(gorm.Expr("CASE WHEN from_id = ? THEN to_id ELSE from_id END, id", fromID)).
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// ListBacklinks возвращает входящие связи заметки пользователя.
+// Симметричные связи, исходящие из заметки, тоже считаются входящими.
+func (s *NotesStore) ListBacklinks(ctx context.Context, userID int64, toID int) ([]NoteLink, error) {
+	var links []NoteLink
+	err := s.visibleLinks(s.db.WithContext(ctx)).
+		Where("user_id = ? AND (to_id = ? OR (from_id = ? AND kind IN ?))", userID, toID, toID, symmetricLinkKinds()).
+		Order("id asc").
 		Find(&links).Error
 	if err != nil {
 		return nil, err
@@ -182,6 +442,124 @@ func (s *NotesStore) IsUserAuthorized(ctx context.Context, userID int64) (bool,
 	return count > 0, nil
 }
 
+// activeNotesBuckets перечисляет группы пользователей по числу активных заметок.
+var activeNotesBuckets = []string{"1-10", "11-100", "101-1000", "1001+"}
+
+// UsersByActiveNotes возвращает число пользователей в каждой группе по количеству активных заметок.
+func (s *NotesStore) UsersByActiveNotes(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		Bucket string
+		Users  int64
+	}
+	err := s.db.WithContext(ctx).Raw(`SELECT CASE
+			WHEN c <= 10 THEN '1-10'
+			WHEN c <= 100 THEN '11-100'
+			WHEN c <= 1000 THEN '101-1000'
+			ELSE '1001+'
+		END AS bucket, COUNT(*) AS users
+		FROM (SELECT user_id, COUNT(*) AS c FROM notes WHERE status = ? GROUP BY user_id) counts
+		GROUP BY bucket`, NoteStatusActive).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	buckets := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		buckets[row.Bucket] = row.Users
+	}
+	return buckets, nil
+}
+
+// ListAuthorizedUsers возвращает всех авторизованных пользователей бота.
+func (s *NotesStore) ListAuthorizedUsers(ctx context.Context) ([]AuthorizedUser, error) {
+	var users []AuthorizedUser
+	if err := s.db.WithContext(ctx).Order("user_id asc").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// RevokeUser отзывает авторизацию пользователя бота.
+func (s *NotesStore) RevokeUser(ctx context.Context, userID int64) (bool, error) {
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&AuthorizedUser{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// PurgeDeletedNotes физически удаляет заметки, помеченные удаленными до момента before.
+// Если userID равен нулю, очищаются заметки всех пользователей: каждый пользователь
+// в своей транзакции. Связи удаляются каскадно.
+func (s *NotesStore) PurgeDeletedNotes(ctx context.Context, userID int64, before time.Time, dryRun bool) (int64, error) {
+	query := s.db.WithContext(ctx).
+		Model(&Note{}).
+		Where("status = ? AND updated_at < ?", NoteStatusDeleted, before)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if dryRun {
+		var count int64
+		err := query.Count(&count).Error
+		return count, err
+	}
+
+	users := []int64{userID}
+	if userID == 0 {
+		users = nil
+		if err := query.Distinct("user_id").Order("user_id").Pluck("user_id", &users).Error; err != nil {
+			return 0, err
+		}
+	}
+	var total int64
+	for _, user := range users {
+		err := s.writeTx(ctx, user, func(tx *gorm.DB) error {
+			result := tx.Where("user_id = ? AND status = ? AND updated_at < ?", user, NoteStatusDeleted, before).
+				Delete(&Note{})
+			total += result.RowsAffected
+			return result.Error
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// anyVersion отключает проверку версии в методах изменения заметок и связей.
+const anyVersion int64 = 0
+
+// whereVersion добавляет к запросу условие на версию, если она задана. Проверка
+// в том же UPDATE или DELETE делает изменение атомарным относительно других клиентов.
+func whereVersion(query *gorm.DB, version int64) *gorm.DB {
+	if version == anyVersion {
+		return query
+	}
+	return query.Where("version = ?", version)
+}
+
+// rowExists проверяет, есть ли строка, удовлетворяющая условию. Используется, чтобы
+// отличить несовпадение версии от отсутствующего объекта.
+func rowExists(query *gorm.DB, condition string, args ...any) (bool, error) {
+	var count int64
+	if err := query.Where(condition, args...).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// translateLinkError превращает нарушения ограничений таблицы связей в ошибки
+// предметной области: параллельная вставка той же связи дает errDuplicateLink,
+// а удаление заметки между проверкой и записью — errNoteNotFound.
+func translateLinkError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return errDuplicateLink
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return errNoteNotFound
+	}
+	return err
+}
+
 // notesExist проверяет, что обе заметки активны и принадлежат пользователю.
 func (s *NotesStore) notesExist(ctx context.Context, userID int64, fromID, toID uint) (bool, error) {
 	var count int64
@@ -194,3 +572,31 @@ func (s *NotesStore) notesExist(ctx context.Context, userID int64, fromID, toID
 	}
 	return count == 2, nil
 }
+
+// linkExists проверяет, есть ли у пользователя такая же связь, кроме exceptID.
+// Для симметричных связей учитывается и обратное направление.
+func (s *NotesStore) linkExists(ctx context.Context, userID int64, exceptID, fromID, toID uint, kind LinkKind) (bool, error) {
+	query := s.db.WithContext(ctx).Model(&NoteLink{}).Where("user_id = ? AND id <> ? AND kind = ?", userID, exceptID, kind)
+	if kind.Symmetric() {
+		query = query.Where("((from_id = ? AND to_id = ?) OR (from_id = ? AND to_id = ?))", fromID, toID, toID, fromID)
+	} else {
+		query = query.Where("from_id = ? AND to_id = ?", fromID, toID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// visibleLinks ограничивает выборку связей согласно политике удаления:
+// при политике hide связи с удаленными заметками не возвращаются.
+func (s *NotesStore) visibleLinks(query *gorm.DB) *gorm.DB {
+	if s.linkPolicy != LinkPolicyHide {
+		return query
+	}
+	return query.Where(
+		"EXISTS (SELECT 1 FROM notes n WHERE n.id = note_links.from_id AND n.status = ?) AND EXISTS (SELECT 1 FROM notes n WHERE n.id = note_links.to_id AND n.status = ?)",
+		NoteStatusActive, NoteStatusActive,
+	)
+}
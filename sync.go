@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultSyncLimit — сколько изменений отдается за один запрос синхронизации по умолчанию.
+	defaultSyncLimit = 500
+	// maxSyncLimit ограничивает параметр limit у GET /v1/sync.
+	maxSyncLimit = 1000
+)
+
+// changeRecord — строка журнала изменений. Журнал заполняется триггерами базы данных
+// (см. migrations/0007_changes.up.sql), поэтому его не нужно обновлять в методах хранилища.
+type changeRecord struct {
+	Entity   string `gorm:"primaryKey"`
+	EntityID uint   `gorm:"primaryKey"`
+	UserID   int64
+	Seq      int64
+	Deleted  bool
+}
+
+// TableName возвращает имя таблицы журнала изменений.
+func (changeRecord) TableName() string {
+	return "changes"
+}
+
+// Виды объектов в журнале изменений.
+const (
+	changeEntityNote = "note"
+	changeEntityLink = "link"
+)
+
+// ChangeEvent описывает одно изменение из журнала. Для существующего объекта
+// заполнено Note или Link, для удаленного — только ID и Deleted.
+// Заметка со статусом deleted тоже считается удаленной.
+type ChangeEvent struct {
+	Seq     int64
+	Entity  string
+	ID      uint
+	Deleted bool
+	Note    *Note
+	Link    *NoteLink
+}
+
+// SyncBatch описывает изменения пользователя после заданного номера.
+// Удаленные заметки, в том числе помеченные как deleted, попадают в DeletedNotes.
+type SyncBatch struct {
+	Notes        []Note
+	Links        []NoteLink
+	DeletedNotes []uint
+	DeletedLinks []uint
+	// Seq — номер последнего изменения в пакете, с него продолжается следующая синхронизация.
+	Seq     int64
+	HasMore bool
+}
+
+// ChangeEvents возвращает до limit изменений пользователя с номером больше since
+// в порядке номеров и признак того, что есть еще изменения.
+func (s *NotesStore) ChangeEvents(ctx context.Context, userID int64, since int64, limit int) ([]ChangeEvent, bool, error) {
+	var (
+		events  []ChangeEvent
+		hasMore bool
+	)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var changes []changeRecord
+		err := tx.Where("user_id = ? AND seq > ?", userID, since).
+			Order("seq asc").
+			Limit(limit + 1).
+			Find(&changes).Error
+		if err != nil {
+			return err
+		}
+		if len(changes) > limit {
+			changes, hasMore = changes[:limit], true
+		}
+
+		var noteIDs, linkIDs []uint
+		for _, change := range changes {
+			if change.Deleted {
+				continue
+			}
+			if change.Entity == changeEntityNote {
+				noteIDs = append(noteIDs, change.EntityID)
+			} else {
+				linkIDs = append(linkIDs, change.EntityID)
+			}
+		}
+		notes := make(map[uint]Note, len(noteIDs))
+		if len(noteIDs) > 0 {
+			var rows []Note
+			if err := tx.Where("user_id = ? AND id IN ?", userID, noteIDs).Find(&rows).Error; err != nil {
+				return err
+			}
+			for _, note := range rows {
+				notes[note.ID] = note
+			}
+		}
+		links := make(map[uint]NoteLink, len(linkIDs))
+		if len(linkIDs) > 0 {
+			var rows []NoteLink
+			if err := tx.Where("user_id = ? AND id IN ?", userID, linkIDs).Find(&rows).Error; err != nil {
+				return err
+			}
+			for _, link := range rows {
+				links[link.ID] = link
+			}
+		}
+
+		events = make([]ChangeEvent, 0, len(changes))
+		for _, change := range changes {
+			event := ChangeEvent{Seq: change.Seq, Entity: change.Entity, ID: change.EntityID, Deleted: true}
+			// Объект, удаленный после чтения журнала, тоже отдается как удаленный.
+			if change.Entity == changeEntityNote {
+				if note, ok := notes[change.EntityID]; ok && !change.Deleted && note.Status == NoteStatusActive {
+					event.Deleted, event.Note = false, &note
+				}
+			} else if link, ok := links[change.EntityID]; ok && !change.Deleted {
+				event.Deleted, event.Link = false, &link
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return events, hasMore, nil
+}
+
+// Changes собирает изменения пользователя после since в пакет для синхронизации.
+// Если изменений нет, Seq равен since.
+func (s *NotesStore) Changes(ctx context.Context, userID int64, since int64, limit int) (SyncBatch, error) {
+	events, hasMore, err := s.ChangeEvents(ctx, userID, since, limit)
+	if err != nil {
+		return SyncBatch{}, err
+	}
+	batch := SyncBatch{Seq: since, HasMore: hasMore}
+	for _, event := range events {
+		batch.Seq = event.Seq
+		switch {
+		case event.Note != nil:
+			batch.Notes = append(batch.Notes, *event.Note)
+		case event.Link != nil:
+			batch.Links = append(batch.Links, *event.Link)
+		case event.Entity == changeEntityNote:
+			batch.DeletedNotes = append(batch.DeletedNotes, event.ID)
+		default:
+			batch.DeletedLinks = append(batch.DeletedLinks, event.ID)
+		}
+	}
+	return batch, nil
+}
+
+// LatestChangeSeq возвращает номер последнего изменения пользователя или ноль.
+func (s *NotesStore) LatestChangeSeq(ctx context.Context, userID int64) (int64, error) {
+	var seq int64
+	err := s.db.WithContext(ctx).Model(&changeRecord{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&seq).Error
+	return seq, err
+}
+
+// SyncOp описывает вид изменения, отправленного клиентом.
+type SyncOp string
+
+const (
+	// SyncOpCreateNote создает заметку.
+	SyncOpCreateNote SyncOp = "create_note"
+	// SyncOpUpdateNote заменяет текст заметки.
+	SyncOpUpdateNote SyncOp = "update_note"
+	// SyncOpDeleteNote помечает заметку как удаленную.
+	SyncOpDeleteNote SyncOp = "delete_note"
+	// SyncOpRestoreNote возвращает удаленную заметку.
+	SyncOpRestoreNote SyncOp = "restore_note"
+	// SyncOpCreateLink создает связь.
+	SyncOpCreateLink SyncOp = "create_link"
+	// SyncOpUpdateLink меняет целевую заметку связи.
+	SyncOpUpdateLink SyncOp = "update_link"
+	// SyncOpDeleteLink удаляет связь.
+	SyncOpDeleteLink SyncOp = "delete_link"
+)
+
+// SyncChange описывает одно изменение, сделанное клиентом без связи с сервером.
+// Заметка, созданная в том же пакете, доступна связям через ClientID:
+// from_client_id и to_client_id ссылаются на него вместо from_id и to_id.
+type SyncChange struct {
+	Op           SyncOp   `json:"op"`
+	ClientID     string   `json:"client_id,omitempty"`
+	ID           uint     `json:"id,omitempty"`
+	Version      int64    `json:"version,omitempty"`
+	Text         string   `json:"text,omitempty"`
+	FromID       uint     `json:"from_id,omitempty"`
+	FromClientID string   `json:"from_client_id,omitempty"`
+	ToID         uint     `json:"to_id,omitempty"`
+	ToClientID   string   `json:"to_client_id,omitempty"`
+	Kind         LinkKind `json:"kind,omitempty"`
+}
+
+// Результаты применения изменения.
+const (
+	// SyncApplied означает, что изменение применено.
+	SyncApplied = "applied"
+	// SyncConflict означает, что объект изменился на сервере; в результате есть его текущее состояние.
+	SyncConflict = "conflict"
+	// SyncRejected означает, что изменение некорректно или объект не существует.
+	SyncRejected = "rejected"
+)
+
+// SyncResult описывает итог применения одного изменения. Note и Link содержат
+// состояние объекта после применения или, при конфликте, текущее состояние на сервере.
+type SyncResult struct {
+	Index    int       `json:"index"`
+	Status   string    `json:"status"`
+	Code     string    `json:"code,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	Note     *Note     `json:"note,omitempty"`
+	Link     *NoteLink `json:"link,omitempty"`
+}
+
+// ApplyChanges применяет изменения клиента по порядку в одной транзакции. Каждое
+// изменение работает в своей точке сохранения: конфликт версий или ошибка проверки
+// попадают в результат и не мешают остальным. Ошибка возвращается только при сбое
+// базы данных, и тогда не сохраняется ни одно изменение пакета, поэтому клиент может
+// безопасно отправить пакет повторно.
+func (s *NotesStore) ApplyChanges(ctx context.Context, userID int64, changes []SyncChange) ([]SyncResult, error) {
+	results := make([]SyncResult, 0, len(changes))
+	applied := false
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		created := make(map[string]uint)
+		for i, change := range changes {
+			result := SyncResult{Index: i, ClientID: change.ClientID}
+			switch change.Op {
+			case SyncOpUpdateNote, SyncOpDeleteNote, SyncOpRestoreNote, SyncOpUpdateLink, SyncOpDeleteLink:
+				// Без версии изменение перезаписало бы правки других клиентов.
+				if change.ID == 0 || change.Version <= 0 {
+					results = append(results, rejectChange(result, newValidation("invalid_payload", "id and version are required for "+string(change.Op))))
+					continue
+				}
+			}
+			err := tx.Transaction(func(item *gorm.DB) error {
+				var err error
+				result, err = s.inTx(item).applyChange(ctx, userID, change, created)
+				if err == nil && result.Status != SyncApplied {
+					err = errBatchItemFailed
+				}
+				return err
+			})
+			if err != nil && !errors.Is(err, errBatchItemFailed) {
+				return fmt.Errorf("change %d: %w", i, err)
+			}
+			result.Index = i
+			results = append(results, result)
+			applied = applied || result.Status == SyncApplied
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if applied {
+		s.changed(userID)
+	}
+	return results, nil
+}
+
+// applyChange применяет одно изменение. created сопоставляет client_id заметок,
+// созданных в этом пакете, их идентификаторам на сервере. Версия anyVersion
+// отключает проверку версии, и отсутствующий объект тогда отклоняется, а не дает конфликт.
+func (s *NotesStore) applyChange(ctx context.Context, userID int64, change SyncChange, created map[string]uint) (SyncResult, error) {
+	result := SyncResult{ClientID: change.ClientID}
+	var err error
+	switch change.Op {
+	case SyncOpCreateNote:
+		text := strings.TrimSpace(change.Text)
+		if text == "" {
+			return rejectChange(result, newValidation("invalid_payload", "text is required")), nil
+		}
+		var note Note
+		note, err = s.AddNote(ctx, userID, text)
+		if err == nil {
+			result.Note = &note
+			if change.ClientID != "" {
+				created[change.ClientID] = note.ID
+			}
+		}
+	case SyncOpUpdateNote, SyncOpDeleteNote, SyncOpRestoreNote:
+		var (
+			note  Note
+			found bool
+		)
+		switch change.Op {
+		case SyncOpUpdateNote:
+			text := strings.TrimSpace(change.Text)
+			if text == "" {
+				return rejectChange(result, newValidation("invalid_payload", "text is required")), nil
+			}
+			note, found, err = s.UpdateNote(ctx, userID, int(change.ID), text, change.Version)
+		case SyncOpDeleteNote:
+			found, err = s.DeleteNote(ctx, userID, int(change.ID), change.Version)
+		default:
+			note, found, err = s.RestoreNote(ctx, userID, int(change.ID), change.Version)
+		}
+		if err == nil && found && change.Op != SyncOpDeleteNote {
+			result.Note = &note
+		}
+		if err == nil && !found && change.Version == anyVersion {
+			return rejectChange(result, errNoteNotFound), nil
+		}
+		if (err == nil && !found) || errors.Is(err, errVersionMismatch) {
+			return s.noteConflict(ctx, userID, change.ID, result)
+		}
+	case SyncOpCreateLink:
+		fromID, toID := resolveClientID(change.FromID, change.FromClientID, created), resolveClientID(change.ToID, change.ToClientID, created)
+		if fromID == 0 || toID == 0 {
+			return rejectChange(result, errNoteNotFound), nil
+		}
+		var link NoteLink
+		link, err = s.AddLink(ctx, userID, int(fromID), int(toID), change.Kind)
+		if err == nil {
+			result.Link = &link
+		}
+	case SyncOpUpdateLink, SyncOpDeleteLink:
+		var (
+			link  NoteLink
+			found bool
+		)
+		if change.Op == SyncOpUpdateLink {
+			toID := resolveClientID(change.ToID, change.ToClientID, created)
+			if toID == 0 {
+				return rejectChange(result, errNoteNotFound), nil
+			}
+			link, found, err = s.UpdateLink(ctx, userID, change.ID, toID, change.Version)
+			if err == nil && found {
+				result.Link = &link
+			}
+		} else {
+			found, err = s.DeleteLink(ctx, userID, change.ID, change.Version)
+		}
+		if err == nil && !found {
+			return rejectChange(result, errLinkNotFound), nil
+		}
+		if errors.Is(err, errVersionMismatch) {
+			return s.linkConflict(ctx, userID, change.ID, result)
+		}
+	default:
+		return rejectChange(result, newValidation("invalid_payload", "unknown op "+string(change.Op))), nil
+	}
+
+	var domain *DomainError
+	switch {
+	case errors.As(err, &domain):
+		return rejectChange(result, domain), nil
+	case err != nil:
+		return SyncResult{}, err
+	}
+	result.Status = SyncApplied
+	return result, nil
+}
+
+// RestoreNote возвращает удаленную заметку в активное состояние. Для активной,
+// чужой или несуществующей заметки возвращается false. Версия проверяется так же,
+// как в UpdateNote. Связи, удаленные политикой cascade, не восстанавливаются.
+func (s *NotesStore) RestoreNote(ctx context.Context, userID int64, id int, version int64) (Note, bool, error) {
+	var restored bool
+	err := s.writeTx(ctx, userID, func(tx *gorm.DB) error {
+		res := whereVersion(tx.Model(&Note{}), version).
+			Where("user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusDeleted).
+			Updates(map[string]any{"status": NoteStatusActive, "version": gorm.Expr("version + 1")})
+		if res.Error != nil {
+			return res.Error
+		}
+		restored = res.RowsAffected > 0
+		if restored || version == anyVersion {
+			return nil
+		}
+		found, err := rowExists(tx.Model(&Note{}), "user_id = ? AND id = ? AND status = ?", userID, id, NoteStatusDeleted)
+		if err != nil || !found {
+			return err
+		}
+		return errVersionMismatch
+	})
+	if err != nil || !restored {
+		return Note{}, false, err
+	}
+	s.changed(userID)
+	return s.GetNote(ctx, userID, id)
+}
+
+// noteConflict сообщает о конфликте с текущим состоянием заметки, в том числе удаленной.
+// Если заметки нет совсем, изменение отклоняется.
+func (s *NotesStore) noteConflict(ctx context.Context, userID int64, id uint, result SyncResult) (SyncResult, error) {
+	var note Note
+	err := s.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&note).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rejectChange(result, errNoteNotFound), nil
+	}
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result.Status, result.Code, result.Detail = SyncConflict, errVersionMismatch.Code, errVersionMismatch.Message
+	result.Note = &note
+	return result, nil
+}
+
+// linkConflict сообщает о конфликте с текущим состоянием связи.
+func (s *NotesStore) linkConflict(ctx context.Context, userID int64, id uint, result SyncResult) (SyncResult, error) {
+	var link NoteLink
+	err := s.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rejectChange(result, errLinkNotFound), nil
+	}
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result.Status, result.Code, result.Detail = SyncConflict, errVersionMismatch.Code, errVersionMismatch.Message
+	result.Link = &link
+	return result, nil
+}
+
+// rejectChange заполняет результат отклоненного изменения.
+func rejectChange(result SyncResult, err *DomainError) SyncResult {
+	result.Status, result.Code, result.Detail = SyncRejected, err.Code, err.Message
+	result.Note, result.Link = nil, nil
+	return result
+}
+
+// resolveClientID возвращает идентификатор заметки: явный id или id заметки,
+// созданной в том же пакете с заданным client_id. Ноль означает, что заметка не найдена.
+func resolveClientID(id uint, clientID string, created map[string]uint) uint {
+	if clientID != "" {
+		return created[clientID]
+	}
+	return id
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesDoNotDeadlock(t *testing.T) {
+	store, userID := testStore(t)
+	ctx := context.Background()
+	first, err := store.AddNote(ctx, userID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.AddNote(ctx, userID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Пакеты меняют одни и те же заметки в противоположном порядке, а одиночные
+	// изменения вклиниваются между ними. Без блокировки журнала до записи такие
+	// транзакции взаимно блокируются.
+	var wg sync.WaitGroup
+	errs := make(chan error, 60)
+	for i := range 20 {
+		order := []uint{first.ID, second.ID}
+		if i%2 == 1 {
+			order = []uint{second.ID, first.ID}
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			changes := []SyncChange{
+				{Op: SyncOpUpdateNote, ID: order[0], Text: fmt.Sprintf("batch %d", i)},
+				{Op: SyncOpUpdateNote, ID: order[1], Text: fmt.Sprintf("batch %d", i)},
+			}
+			if _, _, err := store.ApplyBatch(ctx, userID, BatchAtomic, changes); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, _, err := store.UpdateNote(ctx, userID, int(order[1]), fmt.Sprintf("single %d", i), anyVersion); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	batch, err := store.Changes(ctx, userID, 0, maxSyncLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Notes) != 2 {
+		t.Errorf("changed notes = %+v, want both", batch.Notes)
+	}
+}
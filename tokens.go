@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// apiTokenBytes задает длину случайной части токена.
+const apiTokenBytes = 32
+
+// APIToken описывает выпущенный токен доступа к HTTP API.
+// В базе хранится только SHA-256 от токена.
+type APIToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Name      string     `gorm:"not null" json:"name"`
+	TokenHash string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IssueAPIToken выпускает новый токен и возвращает его открытое значение.
+// Открытое значение больше нигде не сохраняется.
+func (s *NotesStore) IssueAPIToken(ctx context.Context, name string) (string, APIToken, error) {
+	raw := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", APIToken{}, err
+	}
+	plain := hex.EncodeToString(raw)
+
+	token := APIToken{Name: name, TokenHash: hashAPIToken(plain)}
+	if err := s.db.WithContext(ctx).Create(&token).Error; err != nil {
+		return "", APIToken{}, err
+	}
+	return plain, token, nil
+}
+
+// ListAPITokens возвращает все выпущенные токены.
+func (s *NotesStore) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	var tokens []APIToken
+	if err := s.db.WithContext(ctx).Order("id asc").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken отзывает токен по идентификатору.
+func (s *NotesStore) RevokeAPIToken(ctx context.Context, id uint) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Model(&APIToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// VerifyAPIToken проверяет, что токен выпущен и не отозван.
+func (s *NotesStore) VerifyAPIToken(ctx context.Context, plain string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).
+		Model(&APIToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hashAPIToken(plain)).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// hashAPIToken вычисляет SHA-256 от токена в шестнадцатеричном виде.
+func hashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
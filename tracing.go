@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TraceExporter определяет, куда отправляются завершенные спаны.
+type TraceExporter string
+
+const (
+	// TraceExporterNone отключает трассировку.
+	TraceExporterNone TraceExporter = "none"
+	// TraceExporterStdout пишет спаны построчно в JSON в стандартный вывод.
+	TraceExporterStdout TraceExporter = "stdout"
+	// TraceExporterOTLP отправляет спаны в коллектор по OTLP/HTTP в формате JSON.
+	TraceExporterOTLP TraceExporter = "otlp"
+)
+
+// Valid проверяет, что экспортер известен.
+func (e TraceExporter) Valid() bool {
+	return e == TraceExporterNone || e == TraceExporterStdout || e == TraceExporterOTLP
+}
+
+// SpanKind соответствует видам спанов OpenTelemetry.
+type SpanKind int
+
+const (
+	// SpanKindInternal описывает внутреннюю операцию.
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer описывает обработку входящего HTTP-запроса.
+	SpanKindServer SpanKind = 2
+	// SpanKindClient описывает исходящий запрос, например к базе данных или Telegram.
+	SpanKindClient SpanKind = 3
+	// SpanKindConsumer описывает обработку полученного обновления Telegram.
+	SpanKindConsumer SpanKind = 5
+)
+
+const (
+	// traceBatchSize задает число спанов, после которого пакет отправляется сразу.
+	traceBatchSize = 256
+	// traceFlushInterval задает период отправки неполного пакета.
+	traceFlushInterval = 5 * time.Second
+	// traceQueueSize ограничивает очередь спанов; при переполнении спаны отбрасываются.
+	traceQueueSize = 4096
+)
+
+// spanContext идентифицирует спан и передается между процессами в заголовке traceparent.
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// valid проверяет, что идентификаторы не нулевые.
+func (sc spanContext) valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// traceparent формирует заголовок W3C Trace Context.
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// parseTraceparent разбирает заголовок W3C Trace Context версии 00.
+func parseTraceparent(value string) (spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return spanContext{}, false
+	}
+	var sc spanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return spanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || !sc.valid() {
+		return spanContext{}, false
+	}
+	sc.Sampled = flags&1 == 1
+	return sc, true
+}
+
+// Span описывает одну операцию трассировки. Методы безопасно вызывать у nil.
+type Span struct {
+	tracer     *Tracer
+	context    spanContext
+	parentID   [8]byte
+	name       string
+	kind       SpanKind
+	start      time.Time
+	mu         sync.Mutex
+	attributes map[string]any
+	err        error
+	ended      bool
+}
+
+// SetName меняет имя спана, например когда маршрут стал известен после обработки.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr добавляет атрибут спана.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError отмечает спан как завершившийся ошибкой.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End завершает спан и передает его экспортеру, если спан попал в выборку.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := spanData{
+		TraceID:    hex.EncodeToString(s.context.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.context.SpanID[:]),
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      s.start,
+		End:        time.Now(),
+		Attributes: s.attributes,
+	}
+	if s.parentID != [8]byte{} {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		data.Error = s.err.Error()
+	}
+	s.mu.Unlock()
+	if s.context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// spanData хранит завершенный спан для экспорта.
+type spanData struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// spanExporter отправляет пакет завершенных спанов.
+type spanExporter interface {
+	Export(ctx context.Context, spans []spanData) error
+}
+
+// Tracer создает спаны и в фоне отправляет их экспортеру пакетами.
+// Нулевой указатель означает выключенную трассировку.
+type Tracer struct {
+	exporter    spanExporter
+	sampleRatio float64
+	queue       chan spanData
+	flush       chan chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+// appTracer используется всеми компонентами процесса; nil, пока трассировка не настроена.
+var appTracer *Tracer
+
+// setupTracing создает трассировщик по конфигурации и делает его глобальным.
+// При TRACE_EXPORTER=none возвращается nil, и спаны не создаются.
+func setupTracing(config Config) *Tracer {
+	var exporter spanExporter
+	switch config.TraceExporter {
+	case TraceExporterStdout:
+		exporter = &jsonLinesExporter{w: os.Stdout}
+	case TraceExporterOTLP:
+		exporter = &otlpExporter{
+			endpoint:    strings.TrimRight(config.TraceEndpoint, "/") + "/v1/traces",
+			serviceName: config.TraceServiceName,
+			client:      &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		appTracer = nil
+		return nil
+	}
+	appTracer = newTracer(exporter, config.TraceSampleRatio)
+	return appTracer
+}
+
+// newTracer создает трассировщик и запускает фоновую отправку спанов.
+func newTracer(exporter spanExporter, sampleRatio float64) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		sampleRatio: sampleRatio,
+		queue:       make(chan spanData, traceQueueSize),
+		flush:       make(chan chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start создает дочерний спан текущего спана из контекста или новый корневой спан.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent, _ := ctx.Value(spanKey).(spanContext)
+	return t.start(ctx, parent, name, kind)
+}
+
+// StartRemote создает спан, продолжающий трассу из заголовка traceparent.
+func (t *Tracer) StartRemote(ctx context.Context, traceparent, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent, _ := parseTraceparent(traceparent)
+	return t.start(ctx, parent, name, kind)
+}
+
+// start создает спан с заданным родителем; без родителя решение о выборке принимается заново.
+func (t *Tracer) start(ctx context.Context, parent spanContext, name string, kind SpanKind) (context.Context, *Span) {
+	sc := spanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.valid() {
+		sc.TraceID = randomTraceID()
+		sc.Sampled = t.sample()
+	}
+	sc.SpanID = randomSpanID()
+	span := &Span{
+		tracer:     t,
+		context:    sc,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]any),
+	}
+	if parent.valid() {
+		span.parentID = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey, sc), span
+}
+
+// sample решает, попадает ли новая трасса в выборку.
+func (t *Tracer) sample() bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+	var raw [8]byte
+	_, _ = rand.Read(raw[:])
+	return float64(binary.BigEndian.Uint64(raw[:])>>11)/(1<<53) < t.sampleRatio
+}
+
+// enqueue ставит спан в очередь на отправку и отбрасывает его при переполнении.
+func (t *Tracer) enqueue(data spanData) {
+	select {
+	case t.queue <- data:
+	default:
+		slog.Debug("trace queue is full, span dropped", "span", data.Name)
+	}
+}
+
+// run собирает спаны в пакеты и отправляет их по размеру, таймеру или запросу Flush.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]spanData, 0, traceBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			slog.Warn("trace export failed", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= traceBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case reply := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(reply)
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Shutdown отправляет накопленные спаны и останавливает фоновую отправку.
+// Спаны, завершенные после Shutdown, теряются.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	reply := make(chan struct{})
+	select {
+	case t.flush <- reply:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return nil
+}
+
+// traceIDFromContext возвращает идентификатор трассы из контекста или пустую строку.
+func traceIDFromContext(ctx context.Context) string {
+	sc, ok := ctx.Value(spanKey).(spanContext)
+	if !ok || !sc.valid() {
+		return ""
+	}
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// injectTraceparent добавляет заголовок traceparent для исходящего запроса.
+func injectTraceparent(ctx context.Context, header http.Header) {
+	if sc, ok := ctx.Value(spanKey).(spanContext); ok && sc.valid() {
+		header.Set("traceparent", sc.traceparent())
+	}
+}
+
+// randomTraceID генерирует ненулевой идентификатор трассы.
+func randomTraceID() [16]byte {
+	var id [16]byte
+	for id == ([16]byte{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// randomSpanID генерирует ненулевой идентификатор спана.
+func randomSpanID() [8]byte {
+	var id [8]byte
+	for id == ([8]byte{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// TracingMiddleware создает серверный спан на каждый HTTP-запрос и продолжает трассу
+// из заголовка traceparent. Имя спана берется из шаблона маршрута ServeMux.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracer := appTracer
+		if tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := tracer.StartRemote(r.Context(), r.Header.Get("traceparent"), r.Method, SpanKindServer)
+		defer span.End()
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		span.SetAttr("request_id", requestIDFromContext(ctx))
+
+		r = r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if r.Pattern != "" {
+			name := r.Pattern
+			if !strings.HasPrefix(name, r.Method+" ") {
+				name = r.Method + " " + name
+			}
+			span.SetName(name)
+			span.SetAttr("http.route", r.Pattern)
+		}
+		span.SetAttr("http.response.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(recorder.status)))
+		}
+	})
+}
+
+// registerQueryTracing создает спан на каждый запрос GORM через колбэки.
+// Родителем становится спан из контекста, переданного в WithContext.
+func registerQueryTracing(db *gorm.DB) error {
+	const spanInstanceKey = "tracing:span"
+	before := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx, span := appTracer.Start(tx.Statement.Context, "db."+operation, SpanKindClient)
+			if span == nil {
+				return
+			}
+			span.SetAttr("db.system", "postgresql")
+			span.SetAttr("db.operation", operation)
+			if tx.Statement.Table != "" {
+				span.SetAttr("db.collection", tx.Statement.Table)
+			}
+			tx.Statement.Context = ctx
+			tx.InstanceSet(spanInstanceKey, span)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(spanInstanceKey)
+		if !ok {
+			return
+		}
+		span, ok := value.(*Span)
+		if !ok {
+			return
+		}
+		span.SetAttr("db.statement", tx.Statement.SQL.String())
+		span.SetAttr("db.rows_affected", tx.Statement.RowsAffected)
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(tx.Error)
+		}
+		span.End()
+	}
+
+	callbacks := db.Callback()
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", before("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", after),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", before("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", after),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", before("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", after),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", before("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", after),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", before("row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", after),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", before("raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", after),
+	}
+	return errors.Join(errs...)
+}
+
+// jsonLinesExporter пишет каждый спан отдельной строкой JSON.
+type jsonLinesExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Export записывает пакет спанов.
+func (e *jsonLinesExporter) Export(ctx context.Context, spans []spanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpExporter отправляет спаны в коллектор OpenTelemetry по OTLP/HTTP в кодировке JSON.
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// Export отправляет пакет спанов одним запросом.
+func (e *otlpExporter) Export(ctx context.Context, spans []spanData) error {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		item := map[string]any{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+			"status":            map[string]any{"code": 1},
+		}
+		if span.ParentSpanID != "" {
+			item["parentSpanId"] = span.ParentSpanID
+		}
+		if span.Error != "" {
+			item["status"] = map[string]any{"code": 2, "message": span.Error}
+		}
+		otlpSpans = append(otlpSpans, item)
+	}
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": e.serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "notes"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttributes преобразует атрибуты в формат OTLP JSON.
+func otlpAttributes(attributes map[string]any) []map[string]any {
+	result := make([]map[string]any, 0, len(attributes))
+	for _, key := range sortedKeys(attributes) {
+		var value map[string]any
+		switch v := attributes[key].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		result = append(result, map[string]any{"key": key, "value": value})
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// exportedSpans разбирает строки, записанные jsonLinesExporter.
+func exportedSpans(t *testing.T, output *bytes.Buffer) []spanData {
+	t.Helper()
+	var spans []spanData
+	decoder := json.NewDecoder(output)
+	for decoder.More() {
+		var span spanData
+		if err := decoder.Decode(&span); err != nil {
+			t.Fatalf("decode span: %v\n%s", err, output)
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+func TestStdoutExporterWritesSpansOnShutdown(t *testing.T) {
+	var output bytes.Buffer
+	tracer := newTracer(&jsonLinesExporter{w: &output}, 1)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindInternal)
+	_, child := tracer.Start(ctx, "child", SpanKindClient)
+	child.SetAttr("db.operation", "query")
+	child.RecordError(errors.New("no rows"))
+	child.End()
+	parent.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exportedSpans(t, &output)
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2:\n%s", len(spans), output.String())
+	}
+	gotChild, gotParent := spans[0], spans[1]
+	if gotChild.Name != "child" || gotParent.Name != "parent" {
+		t.Fatalf("span names = %q, %q", gotChild.Name, gotParent.Name)
+	}
+	if gotChild.TraceID != gotParent.TraceID || len(gotParent.TraceID) != 32 {
+		t.Errorf("trace ids = %q, %q, want one 32-digit id", gotChild.TraceID, gotParent.TraceID)
+	}
+	if gotChild.ParentSpanID != gotParent.SpanID || gotParent.ParentSpanID != "" {
+		t.Errorf("child parent = %q, parent id = %q, parent parent = %q", gotChild.ParentSpanID, gotParent.SpanID, gotParent.ParentSpanID)
+	}
+	if gotChild.Kind != SpanKindClient || gotChild.Attributes["db.operation"] != "query" || gotChild.Error != "no rows" {
+		t.Errorf("child span = %+v", gotChild)
+	}
+	if gotChild.End.Before(gotChild.Start) {
+		t.Errorf("child span ends before it starts: %+v", gotChild)
+	}
+}
+
+func TestStdoutExporterSkipsUnsampledTraces(t *testing.T) {
+	var output bytes.Buffer
+	tracer := newTracer(&jsonLinesExporter{w: &output}, 0)
+
+	_, span := tracer.Start(context.Background(), "dropped", SpanKindInternal)
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("unsampled span was exported:\n%s", output.String())
+	}
+}
+
+func TestTracingMiddlewareContinuesRemoteTrace(t *testing.T) {
+	var output bytes.Buffer
+	tracer := newTracer(&jsonLinesExporter{w: &output}, 0)
+	previous := appTracer
+	appTracer = tracer
+	defer func() { appTracer = previous }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/notes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/v1/notes/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	TracingMiddleware(mux).ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exportedSpans(t, &output)
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1:\n%s", len(spans), output.String())
+	}
+	span := spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("span does not continue the remote trace: %+v", span)
+	}
+	if span.Name != "GET /v1/notes/{id}" || span.Kind != SpanKindServer {
+		t.Errorf("span name = %q, kind = %d", span.Name, span.Kind)
+	}
+	if span.Attributes["http.route"] != "GET /v1/notes/{id}" || span.Attributes["http.response.status_code"] != float64(http.StatusInternalServerError) {
+		t.Errorf("span attributes = %v", span.Attributes)
+	}
+	if span.Error == "" {
+		t.Errorf("server error is not recorded: %+v", span)
+	}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// webhookBatchSize — сколько доставок диспетчер забирает и отправляет параллельно за раз.
+	webhookBatchSize = 16
+	// webhookMaxBackoff ограничивает паузу между повторными попытками.
+	webhookMaxBackoff = 6 * time.Hour
+	// webhookRetention — сколько хранятся успешные доставки и их журнал.
+	webhookRetention = 7 * 24 * time.Hour
+	// webhookPurgeInterval — как часто удаляются старые успешные доставки.
+	webhookPurgeInterval = time.Hour
+	// webhookResponseLimit — сколько байт ответа получателя дочитывается, чтобы соединение
+	// можно было использовать повторно. Само тело ответа не сохраняется.
+	webhookResponseLimit = 4096
+	// defaultDeliveryLimit и maxDeliveryLimit ограничивают список доставок.
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+// webhookEventNames перечисляет события, на которые можно подписаться.
+// Их формирует триггер enqueue_webhooks, см. migrations/0009_webhooks.up.sql.
+var webhookEventNames = []string{
+	"note.created", "note.updated", "note.deleted", "note.restored",
+	"link.created", "link.updated", "link.deleted",
+}
+
+// Состояния доставки.
+const (
+	// DeliveryPending означает, что доставка ждет первой или повторной попытки.
+	DeliveryPending = "pending"
+	// DeliveryDelivered означает, что получатель ответил кодом 2xx.
+	DeliveryDelivered = "delivered"
+	// DeliveryDead означает, что попытки исчерпаны; доставку можно повторить вручную.
+	DeliveryDead = "dead"
+)
+
+// errWebhookNotFound возвращается, если вебхук не существует или принадлежит другому пользователю.
+var errWebhookNotFound = newNotFound("webhook_not_found", "webhook not found")
+
+// errDeliveryNotFound возвращается, если доставка не существует или относится к другому вебхуку.
+var errDeliveryNotFound = newNotFound("delivery_not_found", "delivery not found")
+
+// errDeliveryDelivered возвращается при попытке повторить уже доставленное событие.
+var errDeliveryDelivered = newConflict("delivery_delivered", "delivery has already succeeded")
+
+// errInvalidWebhookURL возвращается для адреса без схемы http или https либо без хоста.
+var errInvalidWebhookURL = newValidation("invalid_webhook_url", "url must be an absolute http or https URL")
+
+// errUnknownWebhookEvent возвращается для события, которого нет в webhookEventNames.
+var errUnknownWebhookEvent = newValidation("invalid_webhook_event", "events must contain only "+strings.Join(webhookEventNames, ", "))
+
+// errWebhookAddressBlocked возвращается при попытке соединиться с внутренним адресом.
+var errWebhookAddressBlocked = errors.New("address is not allowed for webhooks")
+
+// webhookBlockedPrefixes дополняет проверки webhookAddressAllowed служебными сетями,
+// которые не покрыты методами netip.Addr.
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"), // разделяемые адреса операторов (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),  // служебные адреса IETF
+	netip.MustParsePrefix("198.18.0.0/15"), // сети для тестирования производительности
+}
+
+// WebhookEvents — список событий вебхука. В базе хранится строкой через запятую,
+// пустой список означает подписку на все события.
+type WebhookEvents []string
+
+// Scan читает список из строки базы данных.
+func (e *WebhookEvents) Scan(value any) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("unsupported webhook events value %T", value)
+	}
+	*e = WebhookEvents{}
+	if raw != "" {
+		*e = strings.Split(raw, ",")
+	}
+	return nil
+}
+
+// Value записывает список строкой через запятую.
+func (e WebhookEvents) Value() (driver.Value, error) {
+	return strings.Join(e, ","), nil
+}
+
+// Webhook описывает адрес, на который отправляются события пользователя.
+// Secret отдается только при создании: им получатель проверяет подпись.
+type Webhook struct {
+	ID        uint          `gorm:"primaryKey" json:"id"`
+	UserID    int64         `gorm:"index;not null" json:"user_id"`
+	URL       string        `gorm:"type:text;not null" json:"url"`
+	Secret    string        `gorm:"type:text;not null" json:"secret,omitempty"`
+	Events    WebhookEvents `gorm:"type:text;not null;default:''" json:"events"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// webhookPayload — тело уведомления в jsonb. Оно формируется триггером и отправляется без изменений.
+type webhookPayload []byte
+
+// Scan читает jsonb из базы данных.
+func (p *webhookPayload) Scan(value any) error {
+	switch v := value.(type) {
+	case string:
+		*p = webhookPayload(v)
+	case []byte:
+		*p = append(webhookPayload(nil), v...)
+	default:
+		return fmt.Errorf("unsupported webhook payload value %T", value)
+	}
+	return nil
+}
+
+// Value записывает тело строкой, чтобы PostgreSQL разобрал его как jsonb.
+func (p webhookPayload) Value() (driver.Value, error) {
+	return string(p), nil
+}
+
+// MarshalJSON вставляет тело в ответ API как есть.
+func (p webhookPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+// WebhookDelivery — строка outbox: одно событие для одного вебхука. Строки добавляет
+// триггер в той же транзакции, что и изменение заметки или связи.
+type WebhookDelivery struct {
+	ID            uint             `gorm:"primaryKey" json:"id"`
+	WebhookID     uint             `gorm:"not null" json:"webhook_id"`
+	UserID        int64            `gorm:"not null" json:"-"`
+	Event         string           `gorm:"type:varchar(32);not null" json:"event"`
+	Payload       webhookPayload   `gorm:"type:jsonb;not null" json:"payload"`
+	Status        string           `gorm:"type:varchar(16);not null" json:"status"`
+	Attempts      int              `gorm:"not null" json:"attempts"`
+	NextAttemptAt time.Time        `gorm:"not null" json:"next_attempt_at"`
+	LastError     string           `gorm:"type:text;not null" json:"last_error,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	AttemptLog    []WebhookAttempt `gorm:"foreignKey:DeliveryID" json:"attempt_log"`
+}
+
+// WebhookAttempt описывает одну попытку отправки.
+type WebhookAttempt struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	DeliveryID  uint      `gorm:"not null" json:"-"`
+	AttemptedAt time.Time `gorm:"not null" json:"attempted_at"`
+	StatusCode  int       `gorm:"not null" json:"status_code,omitempty"`
+	Error       string    `gorm:"type:text;not null" json:"error,omitempty"`
+	DurationMS  int64     `gorm:"column:duration_ms;not null" json:"duration_ms"`
+}
+
+// validateWebhook проверяет адрес и события вебхука и убирает повторы событий.
+func validateWebhook(rawURL string, events []string) (WebhookEvents, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errInvalidWebhookURL
+	}
+	normalized := WebhookEvents{}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if !slices.Contains(webhookEventNames, event) {
+			return nil, errUnknownWebhookEvent
+		}
+		if !seen[event] {
+			seen[event] = true
+			normalized = append(normalized, event)
+		}
+	}
+	return normalized, nil
+}
+
+// CreateWebhook регистрирует вебхук пользователя. Пустой secret заменяется случайным,
+// пустой список событий означает все события.
+func (s *NotesStore) CreateWebhook(ctx context.Context, userID int64, rawURL string, events []string, secret string) (Webhook, error) {
+	normalized, err := validateWebhook(rawURL, events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return Webhook{}, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+	webhook := Webhook{UserID: userID, URL: rawURL, Secret: secret, Events: normalized}
+	if err := s.db.WithContext(ctx).Create(&webhook).Error; err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// ListWebhooks возвращает вебхуки пользователя без секретов.
+func (s *NotesStore) ListWebhooks(ctx context.Context, userID int64) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := s.db.WithContext(ctx).Omit("secret").Where("user_id = ?", userID).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// DeleteWebhook удаляет вебхук вместе с его доставками.
+func (s *NotesStore) DeleteWebhook(ctx context.Context, userID int64, id uint) (bool, error) {
+	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&Webhook{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListDeliveries возвращает последние доставки вебхука с журналом попыток, новые первыми.
+// Непустой status оставляет только доставки в этом состоянии; status=dead дает
+// список событий, которые не удалось доставить. Второе значение false, если вебхука нет.
+func (s *NotesStore) ListDeliveries(ctx context.Context, userID int64, webhookID uint, status string, limit int) ([]WebhookDelivery, bool, error) {
+	db := s.db.WithContext(ctx)
+	found, err := rowExists(db.Model(&Webhook{}), "id = ? AND user_id = ?", webhookID, userID)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	query := db.Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var deliveries []WebhookDelivery
+	err = query.Preload("AttemptLog", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, true, err
+}
+
+// RetryDelivery ставит доставку в очередь на немедленную отправку со сброшенным
+// счетчиком попыток. Так повторяются события из списка dead.
+func (s *NotesStore) RetryDelivery(ctx context.Context, userID int64, webhookID, deliveryID uint) (WebhookDelivery, bool, error) {
+	db := s.db.WithContext(ctx)
+	owned := db.Where("id = ? AND webhook_id = ? AND user_id = ?", deliveryID, webhookID, userID)
+	result := owned.Session(&gorm.Session{}).Model(&WebhookDelivery{}).Where("status <> ?", DeliveryDelivered).
+		Updates(map[string]any{
+			"status":          DeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return WebhookDelivery{}, false, result.Error
+	}
+
+	var delivery WebhookDelivery
+	err := owned.Session(&gorm.Session{}).Preload("AttemptLog", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return WebhookDelivery{}, false, nil
+	}
+	if err != nil {
+		return WebhookDelivery{}, false, err
+	}
+	if result.RowsAffected == 0 {
+		return WebhookDelivery{}, true, errDeliveryDelivered
+	}
+	return delivery, true, nil
+}
+
+// webhookJob — доставка вместе с адресом и секретом вебхука.
+type webhookJob struct {
+	delivery WebhookDelivery
+	webhook  Webhook
+}
+
+// claimDeliveries забирает до limit доставок, срок которых наступил, и откладывает их
+// на lease. Параллельные диспетчеры пропускают заблокированные строки, а если процесс
+// остановится посреди отправки, доставка вернется в очередь после lease.
+func (s *NotesStore) claimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhookJob, error) {
+	var deliveries []WebhookDelivery
+	err := s.db.WithContext(ctx).Raw(`UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, time.Now().Add(lease), DeliveryPending, time.Now(), limit).Scan(&deliveries).Error
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.WebhookID)
+	}
+	var webhooks []Webhook
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byID[webhook.ID] = webhook
+	}
+	jobs := make([]webhookJob, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		// Вебхук мог быть удален после выборки: его доставки удалены каскадом.
+		if webhook, ok := byID[delivery.WebhookID]; ok {
+			jobs = append(jobs, webhookJob{delivery: delivery, webhook: webhook})
+		}
+	}
+	return jobs, nil
+}
+
+// finishAttempt записывает попытку в журнал и переводит доставку в status.
+// Для pending nextAttempt задает время следующей попытки.
+func (s *NotesStore) finishAttempt(ctx context.Context, deliveryID uint, attempt WebhookAttempt, status string, nextAttempt time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{
+			"status":          status,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": nextAttempt,
+			"last_error":      attempt.Error,
+		}
+		if status == DeliveryDelivered {
+			updates["delivered_at"] = attempt.AttemptedAt
+		}
+		result := tx.Model(&WebhookDelivery{}).Where("id = ?", deliveryID).Updates(updates)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		attempt.DeliveryID = deliveryID
+		return tx.Create(&attempt).Error
+	})
+}
+
+// purgeDeliveries удаляет успешные доставки старше before. Доставки dead остаются,
+// пока их не повторят или не удалят вместе с вебхуком.
+func (s *NotesStore) purgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("status = ? AND delivered_at < ?", DeliveryDelivered, before).
+		Delete(&WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
+
+// signWebhook вычисляет подпись тела: HMAC-SHA256 от строки "timestamp.body" в hex.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff возвращает паузу перед следующей попыткой: backoff, умноженный
+// на 2 в степени attempts-1, но не больше webhookMaxBackoff.
+func webhookBackoff(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// webhookAddressAllowed сообщает, можно ли отправлять вебхук на адрес ip. Запрещены
+// неуказанные, loopback, частные, link-local и multicast-адреса, в том числе IPv4,
+// записанные как IPv6.
+func webhookAddressAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient создает HTTP-клиент для отправки вебхуков. Адрес проверяется
+// в момент соединения, уже после разрешения имени, поэтому ни имя, указывающее на
+// внутренний адрес, ни смена DNS-записи после регистрации вебхука не открывают доступ
+// к внутренним сервисам. Переадресации не выполняются: ответ 3xx считается ошибкой.
+// Прокси из окружения не используется, иначе проверялся бы адрес прокси.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", errWebhookAddressBlocked, address)
+			}
+			if !webhookAddressAllowed(addr.Addr()) {
+				return fmt.Errorf("%w: %s", errWebhookAddressBlocked, addr.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookDispatcher отправляет доставки из outbox. Очередь хранится в базе, поэтому
+// несколько процессов могут работать с ней одновременно, а после перезапуска
+// неотправленные события не теряются.
+type webhookDispatcher struct {
+	store        *NotesStore
+	client       *http.Client
+	pollInterval time.Duration
+	backoff      time.Duration
+	maxAttempts  int
+	ready        atomic.Bool
+}
+
+// newWebhookDispatcher создает диспетчер с параметрами WEBHOOK_* из конфигурации.
+func newWebhookDispatcher(store *NotesStore, config Config) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:        store,
+		client:       newWebhookClient(config.WebhookTimeout),
+		pollInterval: config.WebhookPollInterval,
+		backoff:      config.WebhookBackoff,
+		maxAttempts:  config.WebhookMaxAttempts,
+	}
+}
+
+// Name возвращает имя компонента.
+func (d *webhookDispatcher) Name() string {
+	return "webhooks"
+}
+
+// Ready сообщает, работает ли цикл отправки.
+func (d *webhookDispatcher) Ready() bool {
+	return d.ready.Load()
+}
+
+// Run опрашивает очередь до отмены ctx. Начатые отправки доводятся до конца:
+// их длительность ограничена WEBHOOK_TIMEOUT.
+func (d *webhookDispatcher) Run(ctx context.Context) error {
+	d.ready.Store(true)
+	defer d.ready.Store(false)
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+	for {
+		for ctx.Err() == nil {
+			sent, err := d.dispatch(ctx)
+			if err != nil {
+				slog.Warn("webhook dispatch failed", "error", err)
+			}
+			if err != nil || sent < webhookBatchSize {
+				break
+			}
+		}
+		if time.Since(lastPurge) >= webhookPurgeInterval && ctx.Err() == nil {
+			lastPurge = time.Now()
+			if purged, err := d.store.purgeDeliveries(ctx, lastPurge.Add(-webhookRetention)); err != nil {
+				slog.Warn("webhook deliveries purge failed", "error", err)
+			} else if purged > 0 {
+				slog.Info("webhook deliveries purged", "count", purged)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch забирает пакет доставок, отправляет их параллельно и возвращает размер пакета.
+func (d *webhookDispatcher) dispatch(ctx context.Context) (int, error) {
+	// Запас сверх тайм-аута нужен, чтобы доставка не вернулась в очередь, пока пишется результат.
+	jobs, err := d.store.claimDeliveries(ctx, webhookBatchSize, d.client.Timeout+time.Minute)
+	if err != nil {
+		return 0, fmt.Errorf("claim deliveries: %w", err)
+	}
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(context.WithoutCancel(ctx), job)
+		}()
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+// deliver выполняет одну попытку и сохраняет ее результат.
+func (d *webhookDispatcher) deliver(ctx context.Context, job webhookJob) {
+	ctx, span := appTracer.Start(ctx, "webhook "+job.delivery.Event, SpanKindClient)
+	defer span.End()
+	span.SetAttr("webhook.id", job.webhook.ID)
+	span.SetAttr("webhook.delivery_id", job.delivery.ID)
+
+	started := time.Now()
+	statusCode, err := d.send(ctx, job)
+	attempt := WebhookAttempt{
+		AttemptedAt: started,
+		StatusCode:  statusCode,
+		DurationMS:  time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		span.RecordError(err)
+	}
+	if statusCode != 0 {
+		span.SetAttr("http.status_code", statusCode)
+	}
+
+	attempts := job.delivery.Attempts + 1
+	status, next, outcome := d.nextState(attempts, err == nil, started)
+	appMetrics.WebhookDeliveries.Inc(outcome)
+	if err != nil {
+		slog.Warn("webhook delivery failed", "webhook_id", job.webhook.ID, "delivery_id", job.delivery.ID,
+			"event", job.delivery.Event, "attempt", attempts, "outcome", outcome, "error", err)
+	}
+	if err := d.store.finishAttempt(ctx, job.delivery.ID, attempt, status, next); err != nil {
+		slog.Error("webhook delivery result not saved", "delivery_id", job.delivery.ID, "error", err)
+	}
+}
+
+// nextState определяет состояние доставки после попытки номер attempts, начатой
+// в started: успех завершает доставку, неудача откладывает следующую попытку по
+// webhookBackoff, а после maxAttempts неудач доставка становится dead.
+// Третье значение — метка результата для метрики webhook_deliveries_total.
+func (d *webhookDispatcher) nextState(attempts int, delivered bool, started time.Time) (string, time.Time, string) {
+	switch {
+	case delivered:
+		return DeliveryDelivered, started, "delivered"
+	case attempts >= d.maxAttempts:
+		return DeliveryDead, started, "dead"
+	default:
+		return DeliveryPending, started.Add(webhookBackoff(d.backoff, attempts)), "retry"
+	}
+}
+
+// send отправляет подписанное уведомление и возвращает код ответа. Ошибкой считается
+// и сбой соединения, и ответ с кодом вне 2xx. Тело ответа не попадает в журнал:
+// получатель мог бы вернуть в нем содержимое внутреннего ресурса.
+func (d *webhookDispatcher) send(ctx context.Context, job webhookJob) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.webhook.URL, bytes.NewReader(job.delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "notes-webhooks/1")
+	req.Header.Set("X-Webhook-Event", job.delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(job.delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(job.webhook.Secret, timestamp, job.delivery.Payload))
+	injectTraceparent(ctx, req.Header)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// handleCreateWebhook регистрирует вебхук и возвращает его вместе с секретом.
+func (a *API) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var payload CreateWebhookRequest
+	if err := decodeJSON(r, "CreateWebhookRequest", &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhook, err := a.store.CreateWebhook(r.Context(), userID, payload.URL, payload.Events, payload.Secret)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+// handleListWebhooks возвращает вебхуки пользователя.
+func (a *API) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhooks, err := a.store.ListWebhooks(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list webhooks: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, emptyIfNil(webhooks))
+}
+
+// handleDeleteWebhook удаляет вебхук и его доставки.
+func (a *API) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deleted, err := a.store.DeleteWebhook(r.Context(), userID, uint(id))
+	if err != nil {
+		writeError(w, r, fmt.Errorf("delete webhook: %w", err))
+		return
+	}
+	if !deleted {
+		writeError(w, r, errWebhookNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListDeliveries возвращает журнал доставок вебхука.
+func (a *API) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryDead:
+	default:
+		writeError(w, r, invalidParameter("status"))
+		return
+	}
+	limit := defaultDeliveryLimit
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = positiveIntFromQuery(r, "limit")
+		if err != nil || limit > maxDeliveryLimit {
+			writeError(w, r, invalidParameter("limit"))
+			return
+		}
+	}
+
+	deliveries, found, err := a.store.ListDeliveries(r.Context(), userID, uint(id), status, limit)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list deliveries: %w", err))
+		return
+	}
+	if !found {
+		writeError(w, r, errWebhookNotFound)
+		return
+	}
+	for i := range deliveries {
+		deliveries[i].AttemptLog = emptyIfNil(deliveries[i].AttemptLog)
+	}
+	writeJSON(w, http.StatusOK, emptyIfNil(deliveries))
+}
+
+// handleRetryDelivery ставит доставку в очередь повторно.
+func (a *API) handleRetryDelivery(w http.ResponseWriter, r *http.Request) {
+	userID, id, err := userAndPathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deliveryID, err := strconv.Atoi(r.PathValue("delivery_id"))
+	if err != nil || deliveryID <= 0 {
+		writeError(w, r, invalidParameter("delivery_id"))
+		return
+	}
+	delivery, found, err := a.store.RetryDelivery(r.Context(), userID, uint(id), uint(deliveryID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !found {
+		writeError(w, r, errDeliveryNotFound)
+		return
+	}
+	delivery.AttemptLog = emptyIfNil(delivery.AttemptLog)
+	writeJSON(w, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testStore подключается к базе из TEST_DATABASE_URL и применяет миграции. Без этой
+// переменной тест пропускается. Каждый тест получает своего пользователя, данные
+// которого удаляются после теста.
+func testStore(t *testing.T) (*NotesStore, int64) {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	store, err := NewNotesStore(databaseURL, LinkPolicyHide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := time.Now().UnixNano()
+	t.Cleanup(func() {
+		for _, table := range []string{"webhooks", "note_links", "notes", "changes", "idempotency_keys"} {
+			if err := store.db.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID).Error; err != nil {
+				t.Errorf("clean %s: %v", table, err)
+			}
+		}
+		_ = store.Close()
+	})
+	return store, userID
+}
+
+// verifySignature проверяет подпись так, как это описано в README для получателя.
+func verifySignature(secret string, header http.Header, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(body)
+	return hmac.Equal([]byte("sha256="+hex.EncodeToString(mac.Sum(nil))), []byte(header.Get("X-Webhook-Signature")))
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"note.created"}`)
+	header := http.Header{}
+	header.Set("X-Webhook-Timestamp", "1700000000")
+	header.Set("X-Webhook-Signature", signWebhook("secret", 1700000000, body))
+	if !verifySignature("secret", header, body) {
+		t.Error("signature does not verify with the same secret")
+	}
+	if verifySignature("other", header, body) {
+		t.Error("signature verifies with another secret")
+	}
+	header.Set("X-Webhook-Timestamp", "1700000001")
+	if verifySignature("secret", header, body) {
+		t.Error("signature verifies with another timestamp")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 512 * 30 * time.Second},
+		{20, webhookMaxBackoff},
+		{1000, webhookMaxBackoff},
+	} {
+		if got := webhookBackoff(30*time.Second, tc.attempts); got != tc.want {
+			t.Errorf("webhookBackoff(30s, %d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestWebhookNextStateDeadLetters(t *testing.T) {
+	d := &webhookDispatcher{backoff: time.Minute, maxAttempts: 3}
+	started := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		attempts  int
+		delivered bool
+		status    string
+		next      time.Time
+		outcome   string
+	}{
+		{1, true, DeliveryDelivered, started, "delivered"},
+		{1, false, DeliveryPending, started.Add(time.Minute), "retry"},
+		{2, false, DeliveryPending, started.Add(2 * time.Minute), "retry"},
+		{3, false, DeliveryDead, started, "dead"},
+		{3, true, DeliveryDelivered, started, "delivered"},
+	} {
+		status, next, outcome := d.nextState(tc.attempts, tc.delivered, started)
+		if status != tc.status || !next.Equal(tc.next) || outcome != tc.outcome {
+			t.Errorf("nextState(%d, %t) = %s, %s, %s; want %s, %s, %s",
+				tc.attempts, tc.delivered, status, next, outcome, tc.status, tc.next, tc.outcome)
+		}
+	}
+}
+
+func TestWebhookSendSignsRequest(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := &webhookDispatcher{client: server.Client()}
+	job := webhookJob{
+		delivery: WebhookDelivery{ID: 42, Event: "note.created", Payload: webhookPayload(`{"event":"note.created"}`)},
+		webhook:  Webhook{ID: 1, URL: server.URL, Secret: "secret"},
+	}
+	status, err := d.send(context.Background(), job)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("send = %d, %v", status, err)
+	}
+	if string(body) != `{"event":"note.created"}` {
+		t.Errorf("body = %s", body)
+	}
+	if header.Get("X-Webhook-Event") != "note.created" || header.Get("X-Webhook-Delivery") != "42" {
+		t.Errorf("headers = %v", header)
+	}
+	if !verifySignature("secret", header, body) {
+		t.Errorf("signature %q does not verify", header.Get("X-Webhook-Signature"))
+	}
+}
+
+func TestWebhookSendDoesNotKeepResponseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	d := &webhookDispatcher{client: server.Client()}
+	status, err := d.send(context.Background(), webhookJob{webhook: Webhook{URL: server.URL}})
+	if status != http.StatusBadGateway || err == nil {
+		t.Fatalf("send = %d, %v", status, err)
+	}
+	if strings.Contains(err.Error(), "internal secret") {
+		t.Errorf("error keeps the response body: %v", err)
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	d := &webhookDispatcher{client: newWebhookClient(time.Second)}
+	for _, target := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		_, err := d.send(context.Background(), webhookJob{webhook: Webhook{URL: target}})
+		if !errors.Is(err, errWebhookAddressBlocked) {
+			t.Errorf("send to %s: error = %v, want %v", target, err, errWebhookAddressBlocked)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Errorf("internal server received %d requests", hits.Load())
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// Проверка адресов отключена заменой транспорта, чтобы достучаться до локального сервера.
+	client := newWebhookClient(time.Second)
+	client.Transport = server.Client().Transport
+	d := &webhookDispatcher{client: client}
+	status, err := d.send(context.Background(), webhookJob{webhook: Webhook{URL: server.URL}})
+	if status != http.StatusTemporaryRedirect || err == nil {
+		t.Errorf("send = %d, %v; want the redirect to fail the attempt", status, err)
+	}
+	if followed.Load() {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestWebhookAddressAllowed(t *testing.T) {
+	for address, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:4700::1111":        true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.64.0.1":             false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"fc00::1":                false,
+		"fe80::1":                false,
+		"224.0.0.1":              false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := webhookAddressAllowed(netip.MustParseAddr(address)); got != want {
+			t.Errorf("webhookAddressAllowed(%s) = %t, want %t", address, got, want)
+		}
+	}
+}
+
+func TestWebhookOutboxFollowsTransaction(t *testing.T) {
+	store, userID := testStore(t)
+	ctx := context.Background()
+	webhook, err := store.CreateWebhook(ctx, userID, "https://example.com/hook", []string{"note.created"}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := store.inTx(tx).AddNote(ctx, userID, "rolled back"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+	deliveries, _, err := store.ListDeliveries(ctx, userID, webhook.ID, "", maxDeliveryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Fatalf("rolled back change produced deliveries: %+v", deliveries)
+	}
+
+	var note Note
+	err = store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		note, err = store.inTx(tx).AddNote(ctx, userID, "committed")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _, err = store.ListDeliveries(ctx, userID, webhook.ID, "", maxDeliveryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != "note.created" || deliveries[0].Status != DeliveryPending {
+		t.Fatalf("deliveries = %+v, want one pending note.created", deliveries)
+	}
+	var payload struct {
+		UserID int64 `json:"user_id"`
+		Data   Note  `json:"data"`
+	}
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.UserID != userID || payload.Data.ID != note.ID {
+		t.Errorf("payload does not describe note %d: %s", note.ID, deliveries[0].Payload)
+	}
+}
+
+func TestWebhookDispatcherDeadLetters(t *testing.T) {
+	store, userID := testStore(t)
+	ctx := context.Background()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	webhook, err := store.CreateWebhook(ctx, userID, server.URL, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddNote(ctx, userID, "undeliverable"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Нулевая пауза позволяет повторить попытку сразу; очередь общая для всех
+	// пользователей, поэтому пакетов может понадобиться больше, чем попыток.
+	d := &webhookDispatcher{store: store, client: server.Client(), maxAttempts: 3}
+	for i := 0; i < 10 && requests.Load() < 3; i++ {
+		if _, err := d.dispatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, _, err := store.ListDeliveries(ctx, userID, webhook.ID, DeliveryDead, maxDeliveryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("dead deliveries = %+v, want one", deliveries)
+	}
+	delivery := deliveries[0]
+	if delivery.Attempts != 3 || len(delivery.AttemptLog) != 3 {
+		t.Fatalf("delivery = %+v, want 3 logged attempts", delivery)
+	}
+	for _, attempt := range delivery.AttemptLog {
+		if attempt.StatusCode != http.StatusServiceUnavailable || attempt.Error == "" {
+			t.Errorf("attempt = %+v", attempt)
+		}
+	}
+}